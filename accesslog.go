@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// accessLogTimeFormat 访问日志中时间戳的格式，精确到微秒便于与客户端日志对齐
+const accessLogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// accessLogEntry 源站访问日志中的一行 (JSON)
+type accessLogEntry struct {
+	Time          string  `json:"time"`
+	TraceID       string  `json:"trace_id"`
+	RemoteAddr    string  `json:"remote_addr"`
+	XForwardedFor string  `json:"x_forwarded_for,omitempty"`
+	ConnID        uint64  `json:"conn_id"`
+	ConnSeq       int64   `json:"conn_seq"` // 该连接上的第几个请求，从 1 开始
+	Method        string  `json:"method"`
+	Host          string  `json:"host"`
+	URI           string  `json:"uri"`
+	Proto         string  `json:"proto"`
+	Range         string  `json:"range,omitempty"`
+	Status        int     `json:"status"`
+	Encoding      string  `json:"encoding,omitempty"`
+	ObjectSize    int     `json:"object_size"`
+	BodyLength    int     `json:"body_length"` // 应发送的响应体长度
+	BytesSent     int64   `json:"bytes_sent"`  // 实际写出的响应体长度
+	Start         string  `json:"start"`
+	HeaderSent    string  `json:"header_sent"`
+	BodyComplete  string  `json:"body_complete"`
+	DurationMs    float64 `json:"duration_ms"`
+	ConnClose     bool    `json:"conn_close,omitempty"` // 发完响应体后源站主动关闭连接
+	BodyFault     string  `json:"body_fault,omitempty"` // 注入的响应体中途故障: fin / rst / stall / noterm
+	Chunked       bool    `json:"chunked,omitempty"`
+	BodyRate      int64   `json:"body_rate,omitempty"` // 本响应的响应体速率 (字节/秒)，-1 表示只受全局带宽上限限制
+	WriteError    string  `json:"write_error,omitempty"`
+}
+
+// accessLogger 异步写访问日志，队列满时丢弃，避免日志写入限制源站吞吐
+type accessLogger struct {
+	ch      chan *accessLogEntry
+	out     *os.File
+	dropped *promCounterVec
+}
+
+var serverAccessLog *accessLogger
+
+// initAccessLog 按 -access-log 打开访问日志，"-" 为标准输出，为空时不记录
+func initAccessLog() {
+	if config.accessLog == "" {
+		return
+	}
+	out := os.Stdout
+	if config.accessLog != "-" {
+		var err error
+		out, err = os.OpenFile(config.accessLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal("打开访问日志失败: ", err)
+		}
+	}
+	l := &accessLogger{
+		ch:      make(chan *accessLogEntry, config.accessLogBuffer),
+		out:     out,
+		dropped: newCounterVec("cache_press_server_access_log_dropped_total", "访问日志队列满时丢弃的日志条数"),
+	}
+	l.dropped.add(0)
+	go l.run()
+	serverAccessLog = l
+}
+
+// log 将一条日志放入写入队列
+func (l *accessLogger) log(e *accessLogEntry) {
+	select {
+	case l.ch <- e:
+	default:
+		l.dropped.inc()
+	}
+}
+
+// run 批量写出日志，队列中没有待写日志时才刷新缓冲区
+func (l *accessLogger) run() {
+	w := bufio.NewWriterSize(l.out, 256<<10)
+	enc := json.NewEncoder(w)
+	for e := range l.ch {
+		_ = enc.Encode(e)
+		if len(l.ch) == 0 {
+			_ = w.Flush()
+		}
+	}
+}
+
+// connInfo 每个客户端连接的编号和请求计数
+type connInfo struct {
+	id  uint64
+	seq int64
+}
+
+type connInfoKey struct{}
+
+var nextConnID uint64
+
+// withConnInfo 作为 http.Server.ConnContext 为每个连接分配编号
+func withConnInfo(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, &connInfo{id: atomic.AddUint64(&nextConnID, 1)})
+}
+
+// nextConnRequest 返回请求所在连接的编号和该连接上的请求序号
+func nextConnRequest(r *http.Request) (uint64, int64) {
+	ci, ok := r.Context().Value(connInfoKey{}).(*connInfo)
+	if !ok {
+		return 0, 0
+	}
+	return ci.id, atomic.AddInt64(&ci.seq, 1)
+}
+
+func formatLogTime(t time.Time) string {
+	return t.Format(accessLogTimeFormat)
+}
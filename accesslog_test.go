@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNextConnRequest(t *testing.T) {
+	ctx1 := withConnInfo(t.Context(), nil)
+	ctx2 := withConnInfo(t.Context(), nil)
+	r1, _ := http.NewRequestWithContext(ctx1, http.MethodGet, "/", nil)
+	r2, _ := http.NewRequestWithContext(ctx2, http.MethodGet, "/", nil)
+
+	id1, seq := nextConnRequest(r1)
+	if seq != 1 {
+		t.Fatalf("first request seq = %d, want 1", seq)
+	}
+	if id, seq := nextConnRequest(r1); id != id1 || seq != 2 {
+		t.Fatalf("second request on same conn: id=%d seq=%d", id, seq)
+	}
+	if id, seq := nextConnRequest(r2); id == id1 || seq != 1 {
+		t.Fatalf("other conn: id=%d seq=%d", id, seq)
+	}
+
+	r3, _ := http.NewRequest(http.MethodGet, "/", nil)
+	if id, seq := nextConnRequest(r3); id != 0 || seq != 0 {
+		t.Fatalf("request without conn info: id=%d seq=%d", id, seq)
+	}
+}
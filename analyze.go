@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// failureEntry 客户端失败日志中的一行 (JSON)，通过 trace id 与源站访问日志关联
+type failureEntry struct {
+	Time        string  `json:"time"`
+	TraceID     string  `json:"trace_id"`
+	Method      string  `json:"method"`
+	URI         string  `json:"uri"`
+	Kind        string  `json:"kind"` // request / status / read_body / short_body / long_body / corrupt / slice_md5
+	Error       string  `json:"error,omitempty"`
+	Status      int     `json:"status,omitempty"`
+	XCache      string  `json:"x_cache,omitempty"`
+	Start       string  `json:"start"`
+	FirstByteMs float64 `json:"first_byte_ms,omitempty"`
+	ElapsedMs   float64 `json:"elapsed_ms"`
+	BytesRead   int64   `json:"bytes_read"`
+}
+
+// failureLogger 客户端失败日志，失败请求通常很少，直接同步写入
+type failureLogger struct {
+	mu  sync.Mutex
+	out *os.File
+}
+
+var failureLog *failureLogger
+
+func initFailureLog() {
+	if config.failureLog == "" {
+		return
+	}
+	f, err := os.OpenFile(config.failureLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal("打开失败日志失败: ", err)
+	}
+	failureLog = &failureLogger{out: f}
+}
+
+// record 记录一次失败请求，resp 为 nil 表示没有收到响应头，firstByte 为 0 表示未知
+func (l *failureLogger) record(req *http.Request, resp *http.Response, kind string, err error,
+	start time.Time, firstByte time.Duration, bytesRead int64) {
+	now := time.Now()
+	e := failureEntry{
+		Time:      formatLogTime(now),
+		TraceID:   req.Header.Get(config.ReqIDHdrName),
+		Method:    req.Method,
+		URI:       req.URL.RequestURI(),
+		Kind:      kind,
+		Start:     formatLogTime(start),
+		ElapsedMs: durationMs(now.Sub(start)),
+		BytesRead: bytesRead,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if resp != nil {
+		e.Status = resp.StatusCode
+		e.XCache = resp.Header.Get("X-Cache")
+		e.FirstByteMs = durationMs(firstByte)
+	}
+	line, _ := json.Marshal(e)
+	l.mu.Lock()
+	l.out.Write(append(line, '\n'))
+	l.mu.Unlock()
+}
+
+// 失败分类
+const (
+	failNeverReachedOrigin = "未到达源站"
+	failOriginClosed       = "源站关闭连接"
+	failOriginSlow         = "源站响应慢"
+	failOriginError        = "源站返回错误状态"
+	failCDNFailed          = "源站正常完成但 CDN 失败"
+)
+
+var failCategories = []string{failNeverReachedOrigin, failOriginClosed, failOriginSlow, failOriginError, failCDNFailed}
+
+// classifyFailure 根据源站访问日志对一次客户端失败分类，origin 为 nil 表示源站没有对应的请求
+func classifyFailure(origin *accessLogEntry, slow time.Duration) string {
+	switch {
+	case origin == nil:
+		return failNeverReachedOrigin
+	case origin.ConnClose || origin.WriteError != "" || origin.BytesSent < int64(origin.BodyLength):
+		return failOriginClosed
+	case time.Duration(origin.DurationMs*float64(time.Millisecond)) >= slow:
+		return failOriginSlow
+	case origin.Status >= 400:
+		return failOriginError
+	default:
+		return failCDNFailed
+	}
+}
+
+// analyzedFailure 关联后的一次失败，-details 输出的一行
+type analyzedFailure struct {
+	Category string          `json:"category"`
+	Client   failureEntry    `json:"client"`
+	Origin   *accessLogEntry `json:"origin,omitempty"`
+}
+
+// readJSONLines 逐行解析 JSON，跳过无法解析的行（如混在标准输出中的其他日志）
+func readJSONLines(file string, fn func(line []byte)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) > 0 && line[0] == '{' {
+			fn(line)
+		}
+	}
+	return scanner.Err()
+}
+
+// runAnalyze 实现 cache_press analyze 子命令：按 trace id 关联客户端失败日志和源站访问日志并分类汇总
+func runAnalyze(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	clientLog := fs.String("failure-log", "", "客户端失败日志 (-failure-log 生成)")
+	originLog := fs.String("access-log", "", "源站访问日志 (-access-log 生成)，可以包含其他非 JSON 行")
+	slow := fs.Duration("slow", time.Second, "源站处理时间超过该值视为源站响应慢")
+	details := fs.String("details", "", "输出每个失败请求的关联结果 (JSON 行)")
+	fs.Parse(args)
+	if *clientLog == "" || *originLog == "" {
+		fmt.Fprintln(os.Stderr, "用法: cache_press analyze -failure-log 客户端失败日志 -access-log 源站访问日志 [-slow 1s] [-details 文件]")
+		os.Exit(2)
+	}
+
+	var failures []analyzedFailure
+	byTrace := make(map[string]int)
+	err := readJSONLines(*clientLog, func(line []byte) {
+		var e failureEntry
+		if json.Unmarshal(line, &e) == nil && e.TraceID != "" {
+			byTrace[e.TraceID] = len(failures)
+			failures = append(failures, analyzedFailure{Client: e})
+		}
+	})
+	if err != nil {
+		log.Fatal("读取客户端失败日志出错: ", err)
+	}
+
+	// 源站日志可能很大，只保留与失败请求关联的记录
+	err = readJSONLines(*originLog, func(line []byte) {
+		var e accessLogEntry
+		if json.Unmarshal(line, &e) != nil {
+			return
+		}
+		if i, ok := byTrace[e.TraceID]; ok {
+			failures[i].Origin = &e
+		}
+	})
+	if err != nil {
+		log.Fatal("读取源站访问日志出错: ", err)
+	}
+
+	counts := make(map[string]int)
+	kinds := make(map[string]map[string]int)
+	for i := range failures {
+		f := &failures[i]
+		f.Category = classifyFailure(f.Origin, *slow)
+		counts[f.Category]++
+		if kinds[f.Category] == nil {
+			kinds[f.Category] = make(map[string]int)
+		}
+		kinds[f.Category][f.Client.Kind]++
+	}
+
+	if *details != "" {
+		if err := writeAnalyzeDetails(*details, failures); err != nil {
+			log.Fatal("写入关联结果失败: ", err)
+		}
+	}
+	printAnalyzeSummary(os.Stdout, len(failures), counts, kinds)
+}
+
+func writeAnalyzeDetails(file string, failures []analyzedFailure) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range failures {
+		if err := enc.Encode(&failures[i]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func printAnalyzeSummary(w io.Writer, total int, counts map[string]int, kinds map[string]map[string]int) {
+	fmt.Fprintf(w, "失败请求数: %d\n", total)
+	fmt.Fprintf(w, "%-28s %8s %8s  %s\n", "分类", "请求数", "占比", "客户端失败类型")
+	for _, c := range failCategories {
+		var ks []string
+		for k := range kinds[c] {
+			ks = append(ks, k)
+		}
+		sort.Strings(ks)
+		detail := ""
+		for _, k := range ks {
+			detail += fmt.Sprintf("%s=%d ", k, kinds[c][k])
+		}
+		fmt.Fprintf(w, "%-28s %8d %7.2f%%  %s\n", c, counts[c], ratio(int64(counts[c]), int64(total))*100, detail)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	cases := []struct {
+		origin *accessLogEntry
+		want   string
+	}{
+		{nil, failNeverReachedOrigin},
+		{&accessLogEntry{BodyLength: 100, BytesSent: 100, ConnClose: true}, failOriginClosed},
+		{&accessLogEntry{BodyLength: 100, BytesSent: 40}, failOriginClosed},
+		{&accessLogEntry{BodyLength: 100, BytesSent: 100, WriteError: "broken pipe"}, failOriginClosed},
+		{&accessLogEntry{BodyLength: 100, BytesSent: 100, DurationMs: 1500}, failOriginSlow},
+		{&accessLogEntry{BodyLength: 100, BytesSent: 100, DurationMs: 10, Status: 503}, failOriginError},
+		{&accessLogEntry{BodyLength: 100, BytesSent: 100, DurationMs: 10, Status: 200}, failCDNFailed},
+	}
+	for i, c := range cases {
+		if got := classifyFailure(c.origin, time.Second); got != c.want {
+			t.Errorf("case %d: got %s, want %s", i, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 响应体限速：源站先立即发送响应头，再按设定速率发送响应体，模拟慢源站或拥塞的回源链路。
+//   - -body-rate:        每个响应的速率 (字节/秒)，固定值如 102400 或区间如 51200-204800（每个响应随机）
+//   - -body-rate-global: 所有连接共享的总带宽上限 (字节/秒)
+//   - -body-jitter:      每次写出之间额外的随机等待
+//
+// 限速时响应体按 bodyPiece 大小分段写出，每段之后 flush。
+
+// bodyRateRange 每个响应的速率区间
+type bodyRateRange struct {
+	min, max int64
+}
+
+var bodyRates bodyRateRange
+
+// globalBodyLimiter 所有响应共享的带宽上限，为 nil 表示不限
+var globalBodyLimiter *byteLimiter
+
+// parseBodyRate 解析 "102400" 或 "51200-204800"，空字符串或 0 表示不限速
+func parseBodyRate(s string) (bodyRateRange, error) {
+	if s == "" || s == "0" {
+		return bodyRateRange{}, nil
+	}
+	lo, hi, isRange := strings.Cut(s, "-")
+	var r bodyRateRange
+	var err1, err2 error
+	r.min, err1 = strconv.ParseInt(strings.TrimSpace(lo), 10, 64)
+	r.max = r.min
+	if isRange {
+		r.max, err2 = strconv.ParseInt(strings.TrimSpace(hi), 10, 64)
+	}
+	if err1 != nil || err2 != nil || r.min <= 0 || r.min > r.max {
+		return bodyRateRange{}, fmt.Errorf("无效的响应体速率 %q，应为正整数(字节/秒)或区间如 51200-204800", s)
+	}
+	return r, nil
+}
+
+// next 返回一个响应使用的速率，0 表示不限速
+func (r bodyRateRange) next() int64 {
+	if r.max <= r.min {
+		return r.min
+	}
+	return r.min + rand.Int63n(r.max-r.min+1)
+}
+
+// byteLimiter 按字节计的令牌桶，可被多个连接并发使用
+type byteLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // 字节/秒
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newByteLimiter 创建速率为 rate 字节/秒的令牌桶，桶容量为 100ms 的流量
+func newByteLimiter(rate int64) *byteLimiter {
+	burst := float64(rate) / 10
+	return &byteLimiter{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve 预定 n 字节，返回需要等待的时间。令牌可以透支，透支部分由之后的请求者按顺序等待
+func (l *byteLimiter) reserve(n int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.After(l.last) {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+	}
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+func (l *byteLimiter) wait(n int) {
+	if d := l.reserve(n, time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// bodyPacer 控制一个响应的响应体发送节奏
+type bodyPacer struct {
+	rate   int64 // 本响应的速率，0 表示只受全局上限限制
+	start  time.Time
+	sent   int64
+	global *byteLimiter
+	jitter time.Duration
+}
+
+// newBodyPacer 在响应头发出后调用，没有配置任何限速时返回 nil
+func newBodyPacer() *bodyPacer {
+	rate := bodyRates.next()
+	if rate == 0 && globalBodyLimiter == nil && config.bodyJitter <= 0 {
+		return nil
+	}
+	return &bodyPacer{rate: rate, start: time.Now(), global: globalBodyLimiter, jitter: config.bodyJitter}
+}
+
+// bodyPiece 限速时每次写出的字节数：约 50ms 的数据量，介于 1KB 和 64KB 之间
+func (p *bodyPacer) bodyPiece() int {
+	if p.rate == 0 {
+		return 16 * 1024
+	}
+	return int(min(max(p.rate/20, 1024), 64*1024))
+}
+
+// wait 在写出 n 字节之前等待：先按本响应的速率对齐已发送的字节数，再从全局令牌桶取令牌，最后加随机抖动
+func (p *bodyPacer) wait(n int) {
+	if p.rate > 0 {
+		due := p.start.Add(time.Duration(float64(p.sent) / float64(p.rate) * float64(time.Second)))
+		if d := time.Until(due); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	if p.global != nil {
+		p.global.wait(n)
+	}
+	if p.jitter > 0 && p.sent > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(p.jitter) + 1)))
+	}
+	p.sent += int64(n)
+}
+
+// logRate 访问日志中记录的速率：未限速为 0，只受全局上限或抖动影响为 -1
+func (p *bodyPacer) logRate() int64 {
+	switch {
+	case p == nil:
+		return 0
+	case p.rate == 0:
+		return -1
+	}
+	return p.rate
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseBodyRate(t *testing.T) {
+	r, err := parseBodyRate("")
+	if err != nil || r.next() != 0 {
+		t.Fatalf("empty: %+v %v", r, err)
+	}
+	r, err = parseBodyRate("1000-2000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if v := r.next(); v < 1000 || v > 2000 {
+			t.Fatalf("rate %d out of range", v)
+		}
+	}
+	for _, bad := range []string{"abc", "-5", "2000-1000", "0-100"} {
+		if _, err := parseBodyRate(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestByteLimiterReserve(t *testing.T) {
+	now := time.Now()
+	l := newByteLimiter(1000)
+	l.last = now
+	// 桶容量 100 字节
+	if d := l.reserve(100, now); d != 0 {
+		t.Fatalf("burst: wait %v", d)
+	}
+	if d := l.reserve(500, now); d != 500*time.Millisecond {
+		t.Fatalf("overdraft: wait %v", d)
+	}
+	// 透支的令牌由之后的请求者继续等待
+	if d := l.reserve(100, now.Add(100*time.Millisecond)); d != 500*time.Millisecond {
+		t.Fatalf("queued: wait %v", d)
+	}
+}
+
+func TestHeadSkipsBodyDelay(t *testing.T) {
+	if serverMetrics == nil {
+		initServerMetrics()
+	}
+	old := config.delayRespBody
+	t.Cleanup(func() { config.delayRespBody = old })
+	config.delayRespBody = 300
+
+	// HEAD 响应没有响应体，不应等待 -delay-resp-body
+	w := httptest.NewRecorder()
+	start := time.Now()
+	serverHandler(w, httptest.NewRequest(http.MethodHead, "/path1_s1024.js", nil))
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Fatalf("HEAD took %v", elapsed)
+	}
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "1024" {
+		t.Fatalf("HEAD: %d body=%d length=%s", w.Code, w.Body.Len(), w.Header().Get("Content-Length"))
+	}
+}
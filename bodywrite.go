@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 响应体中途故障：源站写出部分响应体后
+//   - fin:    关闭连接 (FIN)
+//   - rst:    设置 SO_LINGER 0 后关闭连接，发送 RST
+//   - stall:  不再发送任何数据也不关闭连接，直到客户端断开
+//   - noterm: 以 chunked 编码发送，关闭连接时不发送结束块
+//
+// 前三种先发送完整的 Content-Length。用于测试 CDN 是否会缓存被截断的对象。
+const (
+	bodyFaultFIN    = "fin"
+	bodyFaultRST    = "rst"
+	bodyFaultStall  = "stall"
+	bodyFaultNoTerm = "noterm"
+)
+
+type bodyFaultRate struct {
+	kind string
+	prob float64
+}
+
+// bodyFaultPlan 响应体中途故障的配置
+type bodyFaultPlan struct {
+	rates          []bodyFaultRate
+	minPct, maxPct float64 // 故障前写出的响应体比例区间 (0-100)
+}
+
+var bodyFaults *bodyFaultPlan
+
+// parseBodyFaults 解析 "fin:0.01,rst:0.01,stall:0.005,noterm:0.01" 和故障位置 "50" 或 "10-90"（百分比）
+func parseBodyFaults(spec, at string) (*bodyFaultPlan, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	p := &bodyFaultPlan{}
+	sum := 0.0
+	for _, part := range strings.Split(spec, ",") {
+		kind, prob, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("响应体故障概率应为 类型:概率: %q", part)
+		}
+		switch kind {
+		case bodyFaultFIN, bodyFaultRST, bodyFaultStall, bodyFaultNoTerm:
+		default:
+			return nil, fmt.Errorf("无效的响应体故障类型 %q，应为 fin、rst、stall 或 noterm", kind)
+		}
+		f, err := strconv.ParseFloat(prob, 64)
+		if err != nil || f < 0 {
+			return nil, fmt.Errorf("无效的概率: %q", prob)
+		}
+		sum += f
+		p.rates = append(p.rates, bodyFaultRate{kind, f})
+	}
+	if sum > 1+1e-9 {
+		return nil, fmt.Errorf("响应体故障概率之和 %.3f 超过 1", sum)
+	}
+
+	lo, hi, isRange := strings.Cut(at, "-")
+	var err1, err2 error
+	p.minPct, err1 = strconv.ParseFloat(strings.TrimSpace(lo), 64)
+	p.maxPct = p.minPct
+	if isRange {
+		p.maxPct, err2 = strconv.ParseFloat(strings.TrimSpace(hi), 64)
+	}
+	if err1 != nil || err2 != nil || p.minPct < 0 || p.maxPct > 100 || p.minPct > p.maxPct {
+		return nil, fmt.Errorf("无效的故障位置 %q，应为 0-100 的百分比或百分比区间", at)
+	}
+	return p, nil
+}
+
+// pick 按概率选择故障类型和故障前写出的字节数，kind 为空表示不注入
+func (p *bodyFaultPlan) pick(bodyLen int) (kind string, cutoff int) {
+	if p == nil || bodyLen == 0 {
+		return "", 0
+	}
+	u := rand.Float64()
+	for _, r := range p.rates {
+		if u < r.prob {
+			pct := p.minPct + rand.Float64()*(p.maxPct-p.minPct)
+			cutoff = int(float64(bodyLen) * pct / 100)
+			if cutoff >= bodyLen {
+				// 至少少发一个字节，否则故障不可见
+				cutoff = bodyLen - 1
+			}
+			return r.kind, cutoff
+		}
+		u -= r.prob
+	}
+	return "", 0
+}
+
+// abortConn 在写出部分响应体后按故障类型中断连接，只在注入了响应体故障时调用
+func abortConn(w http.ResponseWriter, kind string) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	switch kind {
+	case bodyFaultRST:
+		if tc, ok := conn.(*net.TCPConn); ok {
+			_ = tc.SetLinger(0)
+		}
+	case bodyFaultStall:
+		// 保持连接直到客户端（或 CDN）放弃并断开
+		_, _ = io.Copy(io.Discard, conn)
+	}
+	conn.Close()
+}
+
+// chunkTrailerName chunked 响应的 trailer，值为实际发送的响应体的 MD5
+const chunkTrailerName = "X-Press-Body-MD5"
+
+// chunkSizeRange chunked 响应每个块的大小区间
+type chunkSizeRange struct {
+	min, max int
+}
+
+var chunkSizes chunkSizeRange
+
+// parseChunkSize 解析 "8192" 或 "1024-16384"
+func parseChunkSize(s string) (chunkSizeRange, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	var c chunkSizeRange
+	var err1, err2 error
+	c.min, err1 = strconv.Atoi(strings.TrimSpace(lo))
+	c.max = c.min
+	if isRange {
+		c.max, err2 = strconv.Atoi(strings.TrimSpace(hi))
+	}
+	if err1 != nil || err2 != nil || c.min <= 0 || c.min > c.max {
+		return chunkSizeRange{}, fmt.Errorf("无效的块大小 %q，应为正整数或区间如 1024-16384", s)
+	}
+	return c, nil
+}
+
+func (c chunkSizeRange) next() int {
+	if c.max <= c.min {
+		return c.min
+	}
+	return c.min + rand.Intn(c.max-c.min+1)
+}
+
+// writeBody 写出响应体。chunked 时按 -chunk-size 分多次写出，启用 -chunk-flush 时每块之后 flush，
+// 此时每次写出对应线上的一个块；不 flush 时小于 Go 输出缓冲 (2KB) 的块会被合并。
+// pacer 不为 nil 时按其节奏分段写出，每段之后 flush
+func writeBody(w http.ResponseWriter, body []byte, chunked bool, pacer *bodyPacer) (int, error) {
+	if !chunked && pacer == nil {
+		return w.Write(body)
+	}
+	flusher, _ := w.(http.Flusher)
+	written := 0
+	for written < len(body) {
+		n := len(body) - written
+		if chunked {
+			n = min(chunkSizes.next(), n)
+		}
+		if pacer != nil {
+			n = min(pacer.bodyPiece(), n)
+			pacer.wait(n)
+		}
+		m, err := w.Write(body[written : written+n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		if (pacer != nil || config.chunkFlush) && flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return written, nil
+}
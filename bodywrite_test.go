@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseBodyFaults(t *testing.T) {
+	if p, err := parseBodyFaults("", "50"); p != nil || err != nil {
+		t.Fatalf("empty spec should disable faults: %v %v", p, err)
+	}
+	p, err := parseBodyFaults("rst:1", "10-90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		kind, cutoff := p.pick(1000)
+		if kind != bodyFaultRST || cutoff < 100 || cutoff > 900 {
+			t.Fatalf("pick: %s at %d", kind, cutoff)
+		}
+	}
+
+	// 故障位置为 100% 时仍少发一个字节
+	p, _ = parseBodyFaults("fin:1", "100")
+	if kind, cutoff := p.pick(10); kind != bodyFaultFIN || cutoff != 9 {
+		t.Errorf("pick at 100%%: %s at %d", kind, cutoff)
+	}
+	if kind, _ := p.pick(0); kind != "" {
+		t.Errorf("empty body should not be faulted")
+	}
+
+	for _, c := range [][2]string{{"fin", "50"}, {"drop:0.1", "50"}, {"fin:x", "50"}, {"fin:0.6,rst:0.6", "50"},
+		{"fin:0.1", "x"}, {"fin:0.1", "90-10"}, {"fin:0.1", "120"}} {
+		if _, err := parseBodyFaults(c[0], c[1]); err == nil {
+			t.Errorf("%q at %q should be invalid", c[0], c[1])
+		}
+	}
+}
+
+func TestParseChunkSize(t *testing.T) {
+	c, err := parseChunkSize("1024-4096")
+	if err != nil || c.min != 1024 || c.max != 4096 {
+		t.Fatalf("got %+v %v", c, err)
+	}
+	for i := 0; i < 1000; i++ {
+		if n := c.next(); n < 1024 || n > 4096 {
+			t.Fatalf("next() = %d out of range", n)
+		}
+	}
+	if c, _ := parseChunkSize("100"); c.next() != 100 {
+		t.Errorf("fixed chunk size: %+v", c)
+	}
+	for _, s := range []string{"", "0", "x", "200-100", "-5"} {
+		if _, err := parseChunkSize(s); err == nil {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
+
+func TestWriteBodyChunked(t *testing.T) {
+	chunkSizes = chunkSizeRange{3, 7}
+	config.chunkFlush = true
+	defer func() { config.chunkFlush = false }()
+	body := genContent(1, 100)
+	w := httptest.NewRecorder()
+	n, err := writeBody(w, body, true, nil)
+	if err != nil || n != len(body) || !bytes.Equal(w.Body.Bytes(), body) || !w.Flushed {
+		t.Fatalf("writeBody: n=%d err=%v flushed=%v", n, err, w.Flushed)
+	}
+}
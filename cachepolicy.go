@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 源站缓存策略表：每条规则为 "模式 指令,指令,..."，规则之间以 ";" 或换行分隔，按顺序第一个匹配的规则生效。
+// 模式的格式见 matchURLPattern。
+// 指令为 Cache-Control 指令，另外支持 expires=秒数 (Expires 头) 和
+// surrogate-max-age=秒数 / surrogate-no-store (Surrogate-Control 头)。
+// URL 中的 _c<n> 指定使用第 n 条规则（从 1 开始），请求头 X-Press-Cache-Control 直接指定 Cache-Control，
+// 客户端可以据此按已知比例混合可缓存和不可缓存的对象。
+const cacheControlOverrideHeader = "X-Press-Cache-Control"
+
+// cachePolicy 一条缓存策略规则
+type cachePolicy struct {
+	pattern      string
+	cacheControl string
+	expires      int // Expires 相对当前时间的秒数，-1 表示不返回
+	surrogate    string
+}
+
+type cachePolicyTable []cachePolicy
+
+var cachePolicies cachePolicyTable
+
+// cacheControlDirectives 支持的 Cache-Control 指令，值为 true 表示需要 =秒数
+var cacheControlDirectives = map[string]bool{
+	"max-age":                true,
+	"s-maxage":               true,
+	"stale-while-revalidate": true,
+	"stale-if-error":         true,
+	"no-store":               false,
+	"no-cache":               false,
+	"private":                false,
+	"public":                 false,
+	"must-revalidate":        false,
+	"proxy-revalidate":       false,
+	"no-transform":           false,
+	"immutable":              false,
+}
+
+func parseCachePolicy(rule string) (cachePolicy, error) {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 {
+		return cachePolicy{}, fmt.Errorf("缓存策略规则应为 \"模式 指令,...\": %q", rule)
+	}
+	p := cachePolicy{pattern: fields[0], expires: -1}
+	if _, err := path.Match(p.pattern, ""); err != nil {
+		return cachePolicy{}, fmt.Errorf("无效的模式 %q: %v", p.pattern, err)
+	}
+
+	var cc, surrogate []string
+	for _, d := range strings.Split(strings.Join(fields[1:], ""), ",") {
+		if d == "" {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.ToLower(d), "=")
+		if hasValue {
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				return cachePolicy{}, fmt.Errorf("指令 %q 的值应为非负整数秒", d)
+			}
+		}
+		switch name {
+		case "expires":
+			if !hasValue {
+				return cachePolicy{}, fmt.Errorf("expires 需要秒数")
+			}
+			p.expires, _ = strconv.Atoi(value)
+		case "surrogate-max-age":
+			if !hasValue {
+				return cachePolicy{}, fmt.Errorf("surrogate-max-age 需要秒数")
+			}
+			surrogate = append(surrogate, "max-age="+value)
+		case "surrogate-no-store":
+			surrogate = append(surrogate, "no-store")
+		default:
+			needValue, ok := cacheControlDirectives[name]
+			if !ok {
+				return cachePolicy{}, fmt.Errorf("不支持的缓存指令 %q", d)
+			}
+			if needValue != hasValue {
+				return cachePolicy{}, fmt.Errorf("缓存指令 %q 格式错误", d)
+			}
+			if hasValue {
+				name += "=" + value
+			}
+			cc = append(cc, name)
+		}
+	}
+	p.cacheControl = strings.Join(cc, ", ")
+	p.surrogate = strings.Join(surrogate, ", ")
+	return p, nil
+}
+
+// parseCachePolicies 解析以 ";" 或换行分隔的规则，"#" 开头的行为注释
+func parseCachePolicies(spec string) (cachePolicyTable, error) {
+	var table cachePolicyTable
+	for _, line := range strings.Split(spec, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, rule := range strings.Split(line, ";") {
+			if strings.TrimSpace(rule) == "" {
+				continue
+			}
+			p, err := parseCachePolicy(rule)
+			if err != nil {
+				return nil, err
+			}
+			table = append(table, p)
+		}
+	}
+	return table, nil
+}
+
+// loadCachePolicies 合并 -cache-policy-file 和 -cache-policy 中的规则，文件中的规则在前
+func loadCachePolicies(file, spec string) (cachePolicyTable, error) {
+	var table cachePolicyTable
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if table, err = parseCachePolicies(string(data)); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	rules, err := parseCachePolicies(spec)
+	if err != nil {
+		return nil, err
+	}
+	return append(table, rules...), nil
+}
+
+// lookup 返回对象适用的规则：URL 中指定的规则编号优先，其次按模式匹配，没有规则时返回 nil
+func (t cachePolicyTable) lookup(urlPath string, obj pressObject) *cachePolicy {
+	if obj.policy > 0 && obj.policy <= len(t) {
+		return &t[obj.policy-1]
+	}
+	for i := range t {
+		if matchURLPattern(t[i].pattern, urlPath) {
+			return &t[i]
+		}
+	}
+	return nil
+}
+
+// setCacheHeaders 按策略设置 Cache-Control、Expires 和 Surrogate-Control，请求头覆盖优先
+func setCacheHeaders(w http.ResponseWriter, r *http.Request, obj pressObject, now time.Time) {
+	if cc := r.Header.Get(cacheControlOverrideHeader); cc != "" {
+		w.Header().Set("Cache-Control", cc)
+		return
+	}
+	p := cachePolicies.lookup(r.URL.Path, obj)
+	if p == nil {
+		return
+	}
+	if p.cacheControl != "" {
+		w.Header().Set("Cache-Control", p.cacheControl)
+	}
+	if p.expires >= 0 {
+		w.Header().Set("Expires", now.Add(time.Duration(p.expires)*time.Second).UTC().Format(http.TimeFormat))
+	}
+	if p.surrogate != "" {
+		w.Header().Set("Surrogate-Control", p.surrogate)
+	}
+}
+
+// cacheMix 客户端按比例为 URL 指定缓存策略规则编号
+type cacheMix struct {
+	policies []int
+	cum      []float64
+}
+
+var urlCacheMix *cacheMix
+
+// parseCacheMix 解析 "规则编号:比例,..."，比例之和不超过 1，剩余部分不指定规则（由源站按模式匹配）
+func parseCacheMix(spec string) (*cacheMix, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	m := &cacheMix{}
+	sum := 0.0
+	for _, part := range strings.Split(spec, ",") {
+		n, w, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("缓存策略比例应为 规则编号:比例: %q", part)
+		}
+		policy, err := strconv.Atoi(n)
+		if err != nil || policy <= 0 {
+			return nil, fmt.Errorf("无效的规则编号: %q", n)
+		}
+		weight, err := strconv.ParseFloat(w, 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("无效的比例: %q", w)
+		}
+		sum += weight
+		m.policies = append(m.policies, policy)
+		m.cum = append(m.cum, sum)
+	}
+	if sum > 1+1e-9 {
+		return nil, fmt.Errorf("缓存策略比例之和 %.3f 超过 1", sum)
+	}
+	return m, nil
+}
+
+// policyFor 按 URL id 确定性地选择规则编号，同一个 URL 每次请求的策略都相同，0 表示不指定
+func (m *cacheMix) policyFor(id int64) int {
+	if m == nil {
+		return 0
+	}
+	u := idUniform(id, 0x63616368)
+	for i, c := range m.cum {
+		if u < c {
+			return m.policies[i]
+		}
+	}
+	return 0
+}
+
+func (m *cacheMix) String() string {
+	if m == nil {
+		return "不指定"
+	}
+	parts := make([]string, len(m.policies))
+	prev := 0.0
+	for i, p := range m.policies {
+		parts[i] = fmt.Sprintf("规则%d=%.2f", p, m.cum[i]-prev)
+		prev = m.cum[i]
+	}
+	return strings.Join(parts, ", ")
+}
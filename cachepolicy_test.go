@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseCachePolicies(t *testing.T) {
+	table, err := parseCachePolicies("# 注释\n.js max-age=60, s-maxage=300;/live/* no-store\n* max-age=10,expires=10,surrogate-max-age=600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table) != 3 {
+		t.Fatalf("got %d rules, want 3", len(table))
+	}
+	if table[0].cacheControl != "max-age=60, s-maxage=300" || table[0].expires != -1 {
+		t.Errorf("rule 1: %+v", table[0])
+	}
+	if table[2].cacheControl != "max-age=10" || table[2].expires != 10 || table[2].surrogate != "max-age=600" {
+		t.Errorf("rule 3: %+v", table[2])
+	}
+
+	for _, spec := range []string{".js", ".js max-age", ".js no-store=1", ".js foo", ".js max-age=-1", "[ max-age=1"} {
+		if _, err := parseCachePolicies(spec); err == nil {
+			t.Errorf("%q should be invalid", spec)
+		}
+	}
+}
+
+func TestCachePolicyLookup(t *testing.T) {
+	table, _ := parseCachePolicies(".js max-age=60; /live/* no-store; * private")
+	cases := []struct {
+		path   string
+		policy int
+		want   string
+	}{
+		{"/path1_s10.js", 0, "max-age=60"},
+		{"/live/a.ts", 0, "no-store"},
+		{"/img/a.jpg", 0, "private"},
+		{"/path1_s10.js", 2, "no-store"},
+		{"/path1_s10.js", 9, "max-age=60"},
+	}
+	for _, c := range cases {
+		if p := table.lookup(c.path, pressObject{policy: c.policy}); p == nil || p.cacheControl != c.want {
+			t.Errorf("%s (_c%d): got %v, want %s", c.path, c.policy, p, c.want)
+		}
+	}
+
+	// URL 中编码的规则编号能被解析
+	if obj := parseObjectPath("/path1_s10_v2_c3.js"); obj.policy != 3 || obj.size != 10 || obj.version != 2 {
+		t.Errorf("parseObjectPath: %+v", obj)
+	}
+}
+
+func TestSetCacheHeaders(t *testing.T) {
+	cachePolicies, _ = parseCachePolicies("* max-age=10,expires=60,surrogate-no-store")
+	defer func() { cachePolicies = nil }()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/a.js", nil)
+	setCacheHeaders(w, r, pressObject{}, now)
+	if w.Header().Get("Cache-Control") != "max-age=10" || w.Header().Get("Surrogate-Control") != "no-store" ||
+		w.Header().Get("Expires") != "Wed, 01 Jan 2025 00:01:00 GMT" {
+		t.Errorf("headers: %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	r.Header.Set(cacheControlOverrideHeader, "no-cache")
+	setCacheHeaders(w, r, pressObject{}, now)
+	if w.Header().Get("Cache-Control") != "no-cache" || w.Header().Get("Expires") != "" {
+		t.Errorf("override headers: %v", w.Header())
+	}
+}
+
+func TestCacheMix(t *testing.T) {
+	m, err := parseCacheMix("1:0.7,2:0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[int]int{}
+	const n = 100000
+	for id := int64(0); id < n; id++ {
+		counts[m.policyFor(id)]++
+	}
+	for policy, want := range map[int]float64{1: 0.7, 2: 0.2, 0: 0.1} {
+		if got := float64(counts[policy]) / n; math.Abs(got-want) > 0.01 {
+			t.Errorf("policy %d: ratio %.3f, want %.2f", policy, got, want)
+		}
+	}
+	if m.policyFor(42) != m.policyFor(42) {
+		t.Errorf("policy should be deterministic per id")
+	}
+	for _, spec := range []string{"1", "0:0.5", "1:x", "1:0.6,2:0.6"} {
+		if _, err := parseCacheMix(spec); err == nil {
+			t.Errorf("%q should be invalid", spec)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
@@ -26,6 +27,55 @@ var done = make(chan bool)
 var totalRequests, successRequests, failedRequests int64
 var totalBytes int64
 
+// corruptRequests 内容校验失败的请求数，与传输错误分开统计
+var corruptRequests int64
+
+// hdrMismatchRequests 头部一致性校验失败的请求数
+var hdrMismatchRequests int64
+
+// shortBodyRequests/longBodyRequests 响应体比应有长度短/长的请求数
+var shortBodyRequests, longBodyRequests int64
+
+// sliceMismatchRequests 分片MD5校验失败的请求数
+var sliceMismatchRequests int64
+
+// stalledRequests 超过 -read-idle-timeout 没有收到数据而取消的请求数，同时计入失败请求
+var stalledRequests int64
+
+// httpErrorRequests 收到非 2xx 状态码的请求数，与传输错误分开统计
+var httpErrorRequests int64
+
+// statusCounts 按 HTTP 状态码统计收到的响应数，下标为状态码
+var statusCounts [600]int64
+
+func recordStatus(code int) {
+	if code < 0 || code >= len(statusCounts) {
+		code = 0
+	}
+	atomic.AddInt64(&statusCounts[code], 1)
+}
+
+// statusBreakdown 返回 状态码 -> 响应数，0 表示无效的状态码
+func statusBreakdown() map[string]int64 {
+	m := make(map[string]int64)
+	for code := range statusCounts {
+		if n := atomic.LoadInt64(&statusCounts[code]); n > 0 {
+			m[strconv.Itoa(code)] = n
+		}
+	}
+	return m
+}
+
+func printStatusBreakdown() {
+	var parts []string
+	for code := range statusCounts {
+		if n := atomic.LoadInt64(&statusCounts[code]); n > 0 {
+			parts = append(parts, fmt.Sprintf("%d=%d", code, n))
+		}
+	}
+	fmt.Printf("状态码分布: %s\n", strings.Join(parts, ", "))
+}
+
 func getBaseURL() string {
 	if config.addr != "" {
 		if !strings.HasPrefix(config.addr, "http") {
@@ -36,17 +86,6 @@ func getBaseURL() string {
 	return fmt.Sprintf("http://%s:%d", config.host, config.port)
 }
 
-func getRespSize() int {
-	if len(config.respSizeRange) == 1 {
-		return config.respSizeRange[0]
-	}
-	minSize, maxSize := config.respSizeRange[0], config.respSizeRange[1]
-	if rand.Float64() <= config.diskRatio {
-		return minSize
-	}
-	return maxSize
-}
-
 // 使用unsafe将字节切片转换为字符串，避免内存分配和复制
 func bytesToString(b []byte) string {
 	return *(*string)(unsafe.Pointer(&b))
@@ -88,20 +127,46 @@ func randString(n int) string {
 func runClient() {
 	// 构建目标URL基础
 	var baseURL = getBaseURL()
+	runWarmup(baseURL)
 
-	limiter := ratelimit.New(config.qps)
+	startTime := time.Now()
+	runStartTime = startTime
+	if runProfile != nil {
+		stageStats = startStageStats(runProfile)
+	}
+
+	clientStat()
+	if config.loadModel == loadModelOpen {
+		runOpenLoop(baseURL, startTime, runDuration(), profileQPSAt(startTime))
+	} else {
+		var limiter rateLimiter
+		if runProfile != nil {
+			limiter = newProfileLimiter(startTime)
+		} else {
+			limiter = ratelimit.New(config.qps)
+		}
+		runClosedLoop(baseURL, startTime, runDuration(), limiter)
+	}
+
+	// 停止监控
+	done <- true
+
+	printFinalStats(baseURL, startTime)
+	saveURLState()
+}
+
+// rateLimiter 闭环模式的限速器，ratelimit.Limiter 和 profileLimiter 都满足该接口
+type rateLimiter interface {
+	Take() time.Time
+}
 
+// runClosedLoop 每个连接协程限速后同步发送请求，直到 startTime 之后 duration 结束
+func runClosedLoop(baseURL string, startTime time.Time, duration time.Duration, limiter rateLimiter) {
 	var wg sync.WaitGroup
 
 	// 控制并发连接数
 	semaphore := make(chan struct{}, config.conns)
 
-	// 统计变量
-
-	var cacheHits int64
-	startTime := time.Now()
-
-	clientStat()
 	// 创建多个goroutine模拟并发请求
 	for i := 0; i < config.conns; i++ {
 		wg.Add(1)
@@ -115,7 +180,7 @@ func runClient() {
 
 			for {
 				// 检查是否到达结束时间
-				if time.Since(startTime) >= config.duration {
+				if time.Since(startTime) >= duration {
 					break
 				}
 
@@ -126,227 +191,448 @@ func runClient() {
 				semaphore <- struct{}{}
 
 				// 生成随机URL
-				url := generateRandomURL(baseURL, config.urlCount, config.hitRatio)
+				url := urlPopularity.nextURL(baseURL)
+				doRequest(client, connID, http.MethodGet, url, time.Time{})
+				<-semaphore
+			}
+		}(i)
+	}
 
-				// 创建请求
-				req, err := http.NewRequest("GET", url, nil)
-				if err != nil {
-					<-semaphore
-					continue
-				}
+	// 等待所有协程完成
+	wg.Wait()
+}
 
-				// 设置请求头 - 包括x-press-size头
-				req.Header.Set("x-press-size", strconv.Itoa(getRespSize()))
-				req.Header.Set("User-Agent", fmt.Sprintf("PressureTestClient-%d", connID))
-				req.Header.Set(config.ReqIDHdrName, fmt.Sprintf("PressureTestClient-%d-%d-%s", connID, time.Now().UnixNano(), randString(6)))
+// printFinalStats 输出最终统计
+func printFinalStats(baseURL string, startTime time.Time) {
+	// 等待监控协程合并完最后一个周期的数据
+	<-statDone
 
-				// 根据CloseConn参数决定是否关闭连接
-				if config.CloseConn > 0 && rand.Float64() <= config.CloseConn {
-					req.Header.Set("Connection", "close")
-				} else {
-					req.Header.Set("Connection", "keep-alive")
-				}
+	endTime := time.Now()
+	elapsed := endTime.Sub(startTime).Seconds()
+	// 命中率的分母为收到响应的请求数，与周期统计和 JSON 报告一致
+	finalHits := runLatency.hits()
+	hitRate := ratio(finalHits, runLatency.count()) * 100
 
-				req.URL.Host = config.addr
-				req.Host = config.host
-				// 记录请求开始时间
-				requestStartTime := time.Now()
-				errFunc := func(err error) {
-					fmt.Println(req.URL.RequestURI(), err)
-					atomic.AddInt64(&failedRequests, 1)
-					atomic.AddInt64(&totalRequests, 1)
-					<-semaphore
-					if !config.ignoreErr {
-						os.Exit(1)
-					}
-				}
-				// 发送请求
-				resp, err := client.Do(req)
-				if err != nil {
-					// 记录失败请求
-					errFunc(err)
-					continue
-				}
-				defer resp.Body.Close()
-				// 记录首包时间（收到响应头的时间）
-				firstByteTime := time.Since(requestStartTime)
-				// 根据clientSendCloseProb决定是否在发送完请求后主动断开连接
-				if config.clientSendCloseProb > 0 && rand.Float64() <= config.clientSendCloseProb {
-					if tcpConn, ok := resp.Body.(interface{ Close() error }); ok {
-						tcpConn.Close()
-					}
-					continue
-				}
+	fmt.Printf("\n=== 最终统计 ===\n")
+	for _, p := range warmupPhases {
+		printPhase(p)
+	}
+	if len(warmupPhases) > 0 {
+		fmt.Printf("以下为正式压测的统计: %s - %s\n", startTime.Format("15:04:05.000"), endTime.Format("15:04:05.000"))
+	}
+	fmt.Printf("目标地址: %s\n", baseURL)
+	fmt.Printf("对象大小分布: %s\n", respSizeDist)
+	fmt.Printf("URL 访问热度模型: %s\n", urlPopularity)
+	if config.loadModel == loadModelOpen {
+		printOpenLoopStats()
+	}
+	fmt.Printf("总请求数: %d\n", totalRequests)
+	fmt.Printf("成功请求数: %d\n", successRequests)
+	fmt.Printf("失败请求数: %d\n", failedRequests)
+	fmt.Printf("HTTP 错误状态数: %d\n", atomic.LoadInt64(&httpErrorRequests))
+	printStatusBreakdown()
+	fmt.Printf("内容损坏数: %d\n", corruptRequests)
+	fmt.Printf("响应体过短数: %d\n", atomic.LoadInt64(&shortBodyRequests))
+	fmt.Printf("响应体过长数: %d\n", atomic.LoadInt64(&longBodyRequests))
+	if config.readIdleTimeout > 0 {
+		fmt.Printf("响应停滞数: %d\n", atomic.LoadInt64(&stalledRequests))
+	}
+	if config.verifySlices {
+		fmt.Printf("分片MD5不一致请求数: %d\n", atomic.LoadInt64(&sliceMismatchRequests))
+	}
+	if headerChecker != nil {
+		fmt.Printf("头部不一致请求数: %d\n", atomic.LoadInt64(&hdrMismatchRequests))
+	}
+	fmt.Printf("缓存命中数: %d\n", finalHits)
+	fmt.Printf("缓存命中率: %.2f%%\n", hitRate)
+	fmt.Printf("总传输字节数: %d\n", totalBytes)
+	fmt.Printf("平均QPS: %.2f\n", float64(totalRequests)/elapsed)
+	fmt.Printf("成功率: %.2f%%\n", float64(successRequests)/float64(totalRequests)*100)
+	fmt.Printf("总耗时: %.2fs\n", elapsed)
+	fmt.Printf("响应体大小分布:\n")
+	observedSizes.print()
+	fmt.Printf("延迟分布:\n")
+	runLatency.print("  ")
+	var stages []stageReport
+	if stageStats != nil {
+		stages = stageStats.report(runProfile)
+		printStageReports(stages)
+	}
+	if headerChecker != nil {
+		headerChecker.print()
+	}
 
-				if resp.StatusCode > 300 {
-					errFunc(fmt.Errorf("请求失败: %s", resp.StatusCode))
-					continue
-				}
+	if config.reportJSON != "" {
+		if err := writeReportJSON(baseURL, startTime, endTime, stages); err != nil {
+			fmt.Println("写入 JSON 报告失败:", err)
+		}
+	}
+}
 
-				//fmt.Println(resp.Status, resp.Header)
-				// 检查 X-Cache 头判断是否命中缓存
-				cacheHit := false
-				xCacheHeader := resp.Header.Get("X-Cache")
-				if strings.Contains(xCacheHeader, "HIT") {
-					cacheHit = true
-				}
+// doRequest 发送一个请求并读取、校验响应，结果计入全局统计。
+// intended 为计划发送时间，首包和响应时间从该时间开始计算，为零值时从实际发送时间开始计算
+func doRequest(client *http.Client, connID int, method, url string, intended time.Time) {
+	// 创建请求
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return
+	}
 
-				// 读取响应体（分块读取，支持中途断开）
-				var readBytes int64
-				var totalExpected int64
+	// 设置请求头 - 包括x-press-size头，与 URL 中编码的大小一致；URL 中没有大小时由源站决定
+	if size := parseObjectPath(req.URL.Path).size; size > 0 {
+		req.Header.Set("x-press-size", strconv.Itoa(size))
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("PressureTestClient-%d", connID))
+	req.Header.Set(config.ReqIDHdrName, fmt.Sprintf("PressureTestClient-%d-%d-%s", connID, time.Now().UnixNano(), randString(6)))
 
-				// 尝试获取Content-Length
-				if clStr := resp.Header.Get("Content-Length"); clStr != "" {
-					totalExpected, _ = strconv.ParseInt(clStr, 10, 64)
-				}
+	// 根据CloseConn参数决定是否关闭连接
+	if config.CloseConn > 0 && rand.Float64() <= config.CloseConn {
+		req.Header.Set("Connection", "close")
+	} else {
+		req.Header.Set("Connection", "keep-alive")
+	}
 
-				// 获取服务器返回的MD5值（如果有）
-				serverMD5 := resp.Header.Get("X-Content-MD5")
+	req.URL.Host = config.addr
+	req.Host = config.host
+
+	// 超过 -read-idle-timeout 没有收到任何数据时取消请求，用于发现挂起的响应
+	var stalled atomic.Bool
+	var idleTimer *time.Timer
+	if config.readIdleTimeout > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		req = req.WithContext(ctx)
+		idleTimer = time.AfterFunc(config.readIdleTimeout, func() {
+			stalled.Store(true)
+			cancel()
+		})
+		defer idleTimer.Stop()
+	}
 
-				// 定义一个读取器，用于分块读取
-				reader := resp.Body
-				err = nil
+	// 记录请求开始时间
+	requestStartTime := time.Now()
+	latencyStart := requestStartTime
+	if !intended.IsZero() {
+		latencyStart = intended
+	}
+	stage := 0
+	if runProfile != nil {
+		stage = runProfile.stageAt(latencyStart.Sub(runStartTime))
+	}
+	// logFailure 在启用 -failure-log 时记录失败请求，供 analyze 子命令与源站访问日志关联
+	var firstByteTime time.Duration
+	logFailure := func(kind string, resp *http.Response, readBytes int64, err error) {
+		if failureLog != nil {
+			failureLog.record(req, resp, kind, err, requestStartTime, firstByteTime, readBytes)
+		}
+	}
+	errFunc := func(kind string, resp *http.Response, err error) {
+		fmt.Println(req.URL.RequestURI(), err)
+		logFailure(kind, resp, 0, err)
+		atomic.AddInt64(&failedRequests, 1)
+		atomic.AddInt64(&totalRequests, 1)
+		if !config.ignoreErr {
+			os.Exit(1)
+		}
+	}
+	// 发送请求，预填充和预热阶段不记录 /metrics 指标
+	recordMetrics := clientMetrics.recording()
+	if recordMetrics {
+		clientMetrics.bytesOut.add(int64(len(method)+len(req.URL.RequestURI())) + headerBytes(req.Header))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// 记录失败请求
+		if recordMetrics {
+			clientMetrics.requests.inc("error", "unknown")
+		}
+		if stalled.Load() {
+			atomic.AddInt64(&stalledRequests, 1)
+			errFunc("stall", nil, err)
+			return
+		}
+		errFunc("request", nil, err)
+		return
+	}
+	defer resp.Body.Close()
+	// 记录首包时间（收到响应头的时间）
+	firstByteTime = time.Since(latencyStart)
+	cacheLabel := cacheStatusLabel(resp.Header.Get("X-Cache"))
+	if recordMetrics {
+		clientMetrics.requests.inc(strconv.Itoa(resp.StatusCode), cacheLabel)
+	}
+	recordStatus(resp.StatusCode)
 
-				// 创建MD5哈希器（仅当服务器返回了MD5值时才计算）
-				var hasher hash.Hash
-				if serverMD5 != "" {
-					hasher = md5.New()
-				}
+	// 头部一致性校验
+	if headerChecker != nil && headerChecker.check(req, resp) > 0 {
+		atomic.AddInt64(&hdrMismatchRequests, 1)
+	}
+	// 根据clientSendCloseProb决定是否在发送完请求后主动断开连接
+	if config.clientSendCloseProb > 0 && rand.Float64() <= config.clientSendCloseProb {
+		if tcpConn, ok := resp.Body.(interface{ Close() error }); ok {
+			tcpConn.Close()
+		}
+		return
+	}
 
-				// 分块读取响应体
-				const chunkSize = 35840
-				chunkPtr := buffer.GetBytes(35840)
-				defer buffer.PutBytes(chunkPtr)
-				chunk := *chunkPtr
-
-				for {
-					n, readErr := reader.Read(chunk)
-					if n > 0 {
-						readBytes += int64(n)
-
-						// 如果需要计算MD5，更新哈希
-						if serverMD5 != "" {
-							hasher.Write(chunk[:n])
-						}
-					}
-
-					// 检查是否需要在接收一半时断开连接
-					if config.clientRecvHalfCloseProb > 0 && rand.Float64() <= config.clientRecvHalfCloseProb {
-						if totalExpected > 0 {
-							// 如果知道总大小，检查是否读取了一半
-							if readBytes >= totalExpected/2 {
-								break
-							}
-						} else {
-							// 如果不知道总大小，随机在某个时刻断开
-							if rand.Float64() <= 0.1 { // 10%概率在每次读取后断开
-								break
-							}
-						}
-					}
-
-					if readErr != nil {
-						if readErr != io.EOF {
-							err = readErr
-						}
-						break
-					}
-				}
+	// 非 2xx 响应按状态码单独统计，读完（较小的）错误响应体以便复用连接
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+		err := fmt.Errorf("请求失败: %d", resp.StatusCode)
+		fmt.Println(req.URL.RequestURI(), err, "X-Cache:", resp.Header.Get("X-Cache"), "源站注入:", resp.Header.Get(faultHeaderName) != "")
+		logFailure("status", resp, 0, err)
+		atomic.AddInt64(&httpErrorRequests, 1)
+		atomic.AddInt64(&totalRequests, 1)
+		if !config.ignoreErr {
+			os.Exit(1)
+		}
+		return
+	}
 
-				// 如果服务器返回了MD5值，验证MD5是否匹配
-				if serverMD5 != "" {
-					calculatedMD5 := hex.EncodeToString(hasher.Sum(nil))
-
-					// 如果启用了测试MD5失败模式，故意修改计算出的MD5值
-					if config.testMD5Failure {
-						// 修改MD5值的最后一个字符
-						if len(calculatedMD5) > 0 {
-							bytes := []byte(calculatedMD5)
-							if bytes[len(bytes)-1] == '9' {
-								bytes[len(bytes)-1] = '0'
-							} else {
-								bytes[len(bytes)-1] = '9'
-							}
-							calculatedMD5 = string(bytes)
-						}
-					}
-
-					if calculatedMD5 != serverMD5 {
-						fmt.Printf("MD5校验失败! 服务器MD5: %s, 客户端计算MD5: %s, URL: %s\n",
-							serverMD5, calculatedMD5, req.URL.Path)
-						if !config.ignoreErr {
-							os.Exit(1)
-						}
-					}
-				}
+	//fmt.Println(resp.Status, resp.Header)
+	// 检查 X-Cache 头判断是否命中缓存
+	cacheHit := false
+	xCacheHeader := resp.Header.Get("X-Cache")
+	if strings.Contains(xCacheHeader, "HIT") {
+		cacheHit = true
+	}
+
+	// 读取响应体（分块读取，支持中途断开）
+	var readBytes int64
+	var totalExpected int64
+
+	// 尝试获取Content-Length
+	if clStr := resp.Header.Get("Content-Length"); clStr != "" {
+		totalExpected, _ = strconv.ParseInt(clStr, 10, 64)
+	}
+
+	// 获取服务器返回的MD5值（如果有）
+	serverMD5 := resp.Header.Get("X-Content-MD5")
+
+	// 定义一个读取器，用于分块读取
+	reader := resp.Body
+	err = nil
+
+	// 创建内容校验器（仅当启用内容校验且响应可校验时）
+	var verifier *bodyVerifier
+	if config.verifyBody {
+		verifier = newBodyVerifier(req.URL.Path, resp)
+	}
+	var sliceVerifier *sliceVerifier
+	if config.verifySlices {
+		sliceVerifier = newSliceVerifier(req.URL.Path, resp)
+	}
+
+	// 创建MD5哈希器（仅当服务器返回了MD5值时才计算）
+	// chunked 响应可能在 trailer 中带有响应体 MD5，响应头中只有声明
+	_, trailerMD5 := resp.Trailer[chunkTrailerName]
+	var hasher hash.Hash
+	if serverMD5 != "" || trailerMD5 {
+		hasher = md5.New()
+	}
+
+	// 分块读取响应体，halfClosed 表示主动中途断开，此时不检查响应体长度
+	halfClosed := false
+	const chunkSize = 35840
+	chunkPtr := buffer.GetBytes(35840)
+	defer buffer.PutBytes(chunkPtr)
+	chunk := *chunkPtr
+
+	for {
+		n, readErr := reader.Read(chunk)
+		if n > 0 {
+			readBytes += int64(n)
+			if idleTimer != nil {
+				idleTimer.Reset(config.readIdleTimeout)
+			}
+
+			// 如果需要计算MD5，更新哈希
+			if hasher != nil {
+				hasher.Write(chunk[:n])
+			}
+
+			// 逐字节校验响应体内容
+			if verifier != nil {
+				verifier.Write(chunk[:n])
+			}
+			if sliceVerifier != nil {
+				sliceVerifier.Write(chunk[:n])
+			}
+		}
 
-				// 记录完整响应时间（收到完整响应体的时间）
-				responseTime := time.Since(requestStartTime)
-
-				select {
-				case reqStatCh <- reqStatInfo{
-					firstByteTime: firstByteTime,
-					respTime:      responseTime,
-					cacheHit:      cacheHit,
-				}:
-				default:
-					// Channel 满时丢弃数据，防止阻塞
-					fmt.Println("！！！丢弃数据，统计通道已满！！！")
+		// 检查是否需要在接收一半时断开连接
+		if config.clientRecvHalfCloseProb > 0 && rand.Float64() <= config.clientRecvHalfCloseProb {
+			if totalExpected > 0 {
+				// 如果知道总大小，检查是否读取了一半
+				if readBytes >= totalExpected/2 {
+					halfClosed = true
+					break
+				}
+			} else {
+				// 如果不知道总大小，随机在某个时刻断开
+				if rand.Float64() <= 0.1 { // 10%概率在每次读取后断开
+					halfClosed = true
+					break
 				}
+			}
+		}
 
-				if err != nil {
-					// 记录失败请求
-					fmt.Println("read body err :",
-						err, req.URL.Path, readBytes, time.Now().Format("2006-01-02 15:04:05.000"), req.Header.Get(config.ReqIDHdrName))
-					if !config.ignoreErr {
-						os.Exit(1)
-					}
-					failedRequests++
+		if readErr != nil {
+			if readErr != io.EOF {
+				err = readErr
+			}
+			break
+		}
+	}
+
+	// 如果服务器返回了MD5值，验证MD5是否匹配
+	// trailer 只有在读完响应体后才可用，CDN 丢弃 trailer 时不校验
+	if serverMD5 == "" && trailerMD5 && err == nil && !halfClosed {
+		serverMD5 = resp.Trailer.Get(chunkTrailerName)
+	}
+	if serverMD5 != "" {
+		calculatedMD5 := hex.EncodeToString(hasher.Sum(nil))
+
+		// 如果启用了测试MD5失败模式，故意修改计算出的MD5值
+		if config.testMD5Failure {
+			// 修改MD5值的最后一个字符
+			if len(calculatedMD5) > 0 {
+				bytes := []byte(calculatedMD5)
+				if bytes[len(bytes)-1] == '9' {
+					bytes[len(bytes)-1] = '0'
 				} else {
-					// 记录成功请求
-					atomic.AddInt64(&successRequests, 1)
-					totalBytes += readBytes
+					bytes[len(bytes)-1] = '9'
 				}
+				calculatedMD5 = string(bytes)
+			}
+		}
 
-				// 接收完响应后主动断开连接
-				if config.clientRecvFullCloseProb > 0 && rand.Float64() <= config.clientRecvFullCloseProb {
-					if resp.Body != nil {
-						resp.Body.Close()
-					}
-				}
+		if calculatedMD5 != serverMD5 {
+			fmt.Printf("MD5校验失败! 服务器MD5: %s, 客户端计算MD5: %s, URL: %s\n",
+				serverMD5, calculatedMD5, req.URL.Path)
+			logFailure("md5", resp, readBytes, fmt.Errorf("服务器MD5: %s, 客户端计算MD5: %s", serverMD5, calculatedMD5))
+			if !config.ignoreErr {
+				os.Exit(1)
+			}
+		}
+	}
 
-				atomic.AddInt64(&totalRequests, 1)
-				<-semaphore
+	// 内容校验失败单独计数，不计入成功和传输失败
+	corrupt := false
+	if verifier != nil {
+		verifier.release()
+		if verifier.mismatch {
+			corrupt = true
+			atomic.AddInt64(&corruptRequests, 1)
+			if recordMetrics {
+				clientMetrics.corrupt.inc(cacheLabel)
 			}
-		}(i)
+			fmt.Printf("内容校验失败! URL: %s, %s, X-Cache: %s, 状态码: %d, Trace-ID: %s\n",
+				req.URL.Path, verifier, resp.Header.Get("X-Cache"), resp.StatusCode, req.Header.Get(config.ReqIDHdrName))
+			logFailure("corrupt", resp, readBytes, fmt.Errorf("%s", verifier))
+			if !config.ignoreErr {
+				os.Exit(1)
+			}
+		}
 	}
 
-	// 等待所有协程完成
-	wg.Wait()
+	// 记录完整响应时间（收到完整响应体的时间）
+	responseTime := time.Since(latencyStart)
+
+	// 响应体长度校验：连接提前结束 (unexpected EOF) 或 CDN 缓存了截断/多余的内容都会导致长度不符
+	if !halfClosed && method != http.MethodHead && (err == nil || err == io.ErrUnexpectedEOF) {
+		if want, ok := expectedBodyLength(req.URL.Path, resp); ok && readBytes != want {
+			kind, failKind := "过短", "short_body"
+			if readBytes < want {
+				atomic.AddInt64(&shortBodyRequests, 1)
+			} else {
+				kind, failKind = "过长", "long_body"
+				atomic.AddInt64(&longBodyRequests, 1)
+			}
+			if err == nil {
+				err = fmt.Errorf("期望长度: %d", want)
+			}
+			logFailure(failKind, resp, readBytes, err)
+			corrupt = true
+			err = nil
+			fmt.Printf("响应体长度%s! URL: %s, 期望: %d, 实际: %d, Content-Length: %d, X-Cache: %s, 状态码: %d, Trace-ID: %s\n",
+				kind, req.URL.Path, want, readBytes, resp.ContentLength, resp.Header.Get("X-Cache"), resp.StatusCode,
+				req.Header.Get(config.ReqIDHdrName))
+			if !config.ignoreErr {
+				os.Exit(1)
+			}
+		}
+	}
 
-	// 停止监控
-	done <- true
+	// 分片MD5校验在记录响应时间之后进行，获取分片清单不计入响应时间
+	if sliceVerifier != nil && !corrupt {
+		if sliceVerifier.needManifest() {
+			sliceVerifier.expected, err = fetchSliceManifest(client, sliceVerifier.manifestPath)
+			if err != nil {
+				fmt.Println("获取分片清单失败:", sliceVerifier.manifestPath, err)
+				err = nil
+			}
+		}
+		sliceVerifier.finish()
+		if sliceVerifier.mismatch {
+			corrupt = true
+			atomic.AddInt64(&sliceMismatchRequests, 1)
+			fmt.Printf("分片MD5校验失败! URL: %s, %s, X-Cache: %s, 状态码: %d, Trace-ID: %s\n",
+				req.URL.Path, sliceVerifier, resp.Header.Get("X-Cache"), resp.StatusCode, req.Header.Get(config.ReqIDHdrName))
+			logFailure("slice_md5", resp, readBytes, fmt.Errorf("%s", sliceVerifier))
+			if !config.ignoreErr {
+				os.Exit(1)
+			}
+		}
+	}
+	if recordMetrics {
+		clientMetrics.bytesIn.add(readBytes)
+		clientMetrics.firstByte.observeDuration(firstByteTime, cacheLabel)
+		clientMetrics.response.observeDuration(responseTime, cacheLabel)
+	}
 
-	// 输出最终统计
-	elapsed := time.Since(startTime).Seconds()
-	finalTotal := atomic.LoadInt64(&totalRequests)
-	finalHits := atomic.LoadInt64(&cacheHits)
+	select {
+	case reqStatCh <- reqStatInfo{
+		firstByteTime: firstByteTime,
+		respTime:      responseTime,
+		cacheHit:      cacheHit,
+		stage:         stage,
+	}:
+	default:
+		// Channel 满时丢弃数据，防止阻塞
+		fmt.Println("！！！丢弃数据，统计通道已满！！！")
+	}
+	if levelStats != nil {
+		levelStats.record(reqStatInfo{firstByteTime: firstByteTime, respTime: responseTime, cacheHit: cacheHit})
+	}
 
-	hitRate := 0.0
-	if finalTotal > 0 {
-		hitRate = float64(finalHits) / float64(finalTotal) * 100
+	if err != nil {
+		// 记录失败请求
+		fmt.Println("read body err :",
+			err, req.URL.Path, readBytes, time.Now().Format("2006-01-02 15:04:05.000"), req.Header.Get(config.ReqIDHdrName))
+		if stalled.Load() {
+			atomic.AddInt64(&stalledRequests, 1)
+			logFailure("stall", resp, readBytes, err)
+		} else {
+			logFailure("read_body", resp, readBytes, err)
+		}
+		if !config.ignoreErr {
+			os.Exit(1)
+		}
+		atomic.AddInt64(&failedRequests, 1)
+	} else if !corrupt {
+		// 记录成功请求
+		atomic.AddInt64(&successRequests, 1)
+		atomic.AddInt64(&totalBytes, readBytes)
+		observedSizes.record(readBytes)
 	}
 
-	fmt.Printf("\n=== 最终统计 ===\n")
-	fmt.Printf("目标地址: %s\n", baseURL)
-	fmt.Printf("响应大小范围: %v, 小响应体比例: %.2f\n", config.respSizeRange, config.diskRatio)
-	fmt.Printf("总请求数: %d\n", totalRequests)
-	fmt.Printf("成功请求数: %d\n", successRequests)
-	fmt.Printf("失败请求数: %d\n", failedRequests)
-	fmt.Printf("缓存命中数: %d\n", finalHits)
-	fmt.Printf("缓存命中率: %.2f%%\n", hitRate)
-	fmt.Printf("总传输字节数: %d\n", totalBytes)
-	fmt.Printf("平均QPS: %.2f\n", float64(totalRequests)/elapsed)
-	fmt.Printf("成功率: %.2f%%\n", float64(successRequests)/float64(totalRequests)*100)
-	fmt.Printf("总耗时: %.2fs\n", elapsed)
+	// 接收完响应后主动断开连接
+	if config.clientRecvFullCloseProb > 0 && rand.Float64() <= config.clientRecvFullCloseProb {
+		if resp.Body != nil {
+			resp.Body.Close()
+		}
+	}
+
+	atomic.AddInt64(&totalRequests, 1)
 }
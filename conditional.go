@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lastModifiedBase 对象 Last-Modified 的基准时间，实际时间由内容种子和版本号确定，
+// 多个源站实例对同一对象给出相同的 Last-Modified
+var lastModifiedBase = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// objectETag 根据内容种子（host + path + 版本号）、对象大小和内容编码生成 ETag，-etag=none 时返回空。
+// 压缩后的响应体与原始内容不同，encoding 非空时 ETag 带上编码后缀，如 "…-400-gzip"
+func objectETag(seed uint64, size int, encoding string) string {
+	tag := fmt.Sprintf(`"%016x-%x"`, seed, size)
+	if encoding != "" {
+		tag = fmt.Sprintf(`"%016x-%x-%s"`, seed, size, encoding)
+	}
+	switch config.etag {
+	case "strong":
+		return tag
+	case "weak":
+		return "W/" + tag
+	}
+	return ""
+}
+
+// objectLastModified 返回对象的修改时间：基准时间 + 版本号小时数 + 由种子确定的一天内偏移
+func objectLastModified(seed uint64, version int64) time.Time {
+	return lastModifiedBase.Add(time.Duration(version)*time.Hour + time.Duration(seed%86400)*time.Second)
+}
+
+// etagWeakMatch 弱比较两个 ETag，忽略 W/ 前缀
+func etagWeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// selectEncoding 根据 Accept-Encoding 选择压缩算法（优先顺序： br -> gzip ），不压缩时返回空
+func selectEncoding(acceptEncoding string) string {
+	switch {
+	case acceptEncoding == "":
+		return ""
+	case strings.Contains(acceptEncoding, "br"):
+		return "br"
+	case strings.Contains(acceptEncoding, "gzip"):
+		return "gzip"
+	}
+	return ""
+}
+
+// checkNotModified 判断条件请求是否应返回 304。If-None-Match 存在时忽略 If-Modified-Since
+func checkNotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+		for _, t := range strings.Split(inm, ",") {
+			if t = strings.TrimSpace(t); t == "*" || etagWeakMatch(t, etag) {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && config.lastModified {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// revalidationStats 按 URL 统计源站返回的 304 和完整 200 次数，用于观察 CDN 的回源校验频率
+type revalidationStats struct {
+	mu     sync.Mutex
+	counts map[string]*[2]int64 // 下标 0 为 200，1 为 304
+}
+
+var revalidations = &revalidationStats{counts: make(map[string]*[2]int64)}
+
+func (s *revalidationStats) record(urlPath string, notModified bool) {
+	i := 0
+	if notModified {
+		i = 1
+	}
+	s.mu.Lock()
+	c, ok := s.counts[urlPath]
+	if !ok {
+		c = new([2]int64)
+		s.counts[urlPath] = c
+	}
+	c[i]++
+	s.mu.Unlock()
+}
+
+// ServeHTTP 在指标服务的 /revalidations 路径输出 "URL 200次数 304次数"，按 304 次数降序，
+// 参数 top 限制输出的 URL 数量（默认 100，0 表示全部）
+func (s *revalidationStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	top := 100
+	if v, err := strconv.Atoi(r.URL.Query().Get("top")); err == nil {
+		top = v
+	}
+
+	type row struct {
+		url    string
+		counts [2]int64
+	}
+	var total [2]int64
+	s.mu.Lock()
+	urls := len(s.counts)
+	rows := make([]row, 0, urls)
+	for u, c := range s.counts {
+		rows = append(rows, row{u, *c})
+		total[0] += c[0]
+		total[1] += c[1]
+	}
+	s.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].counts[1] != rows[j].counts[1] {
+			return rows[i].counts[1] > rows[j].counts[1]
+		}
+		return rows[i].url < rows[j].url
+	})
+	if top > 0 && len(rows) > top {
+		rows = rows[:top]
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "# URL数: %d, 200: %d, 304: %d\n", urls, total[0], total[1])
+	fmt.Fprintf(w, "# url 200 304\n")
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s %d %d\n", r.url, r.counts[0], r.counts[1])
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setConditionalConfig 设置 -etag 和 -last-modified，测试结束后恢复
+func setConditionalConfig(t *testing.T, etag string, lastModified bool) {
+	oldETag, oldLastModified := config.etag, config.lastModified
+	t.Cleanup(func() { config.etag, config.lastModified = oldETag, oldLastModified })
+	config.etag, config.lastModified = etag, lastModified
+}
+
+func TestCheckNotModified(t *testing.T) {
+	setConditionalConfig(t, "weak", true)
+	etag := objectETag(42, 1024, "")
+	if etag != `W/"000000000000002a-400"` {
+		t.Fatalf("unexpected etag %s", etag)
+	}
+	lm := objectLastModified(42, 2)
+
+	cases := []struct {
+		header, value string
+		want          bool
+	}{
+		{"", "", false},
+		{"If-None-Match", `"000000000000002a-400"`, true},
+		{"If-None-Match", `"other", W/"000000000000002a-400"`, true},
+		{"If-None-Match", "*", true},
+		{"If-None-Match", `"other"`, false},
+		{"If-Modified-Since", lm.Format(http.TimeFormat), true},
+		{"If-Modified-Since", lm.Add(-time.Second).Format(http.TimeFormat), false},
+		{"If-Modified-Since", "garbage", false},
+	}
+	for _, c := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "/path1_s1024.js", nil)
+		if c.header != "" {
+			r.Header.Set(c.header, c.value)
+		}
+		if got := checkNotModified(r, etag, lm); got != c.want {
+			t.Errorf("%s: %s => %v, want %v", c.header, c.value, got, c.want)
+		}
+	}
+
+	// If-None-Match 不匹配时忽略 If-Modified-Since
+	r, _ := http.NewRequest(http.MethodGet, "/path1_s1024.js", nil)
+	r.Header.Set("If-None-Match", `"other"`)
+	r.Header.Set("If-Modified-Since", lm.Format(http.TimeFormat))
+	if checkNotModified(r, etag, lm) {
+		t.Errorf("If-Modified-Since should be ignored when If-None-Match is present")
+	}
+}
+
+func TestETagPerEncoding(t *testing.T) {
+	setConditionalConfig(t, "strong", false)
+	if serverMetrics == nil {
+		initServerMetrics()
+	}
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/path1_s1024.js", nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		serverHandler(w, r)
+		return w
+	}
+
+	identity := get(nil).Header().Get("ETag")
+	gz := get(http.Header{"Accept-Encoding": {"gzip"}})
+	gzipETag := gz.Header().Get("ETag")
+	if identity == "" || gzipETag == "" || identity == gzipETag || gz.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("identity %s, gzip %s (%s)", identity, gzipETag, gz.Header().Get("Content-Encoding"))
+	}
+	if br := get(http.Header{"Accept-Encoding": {"br"}}).Header().Get("ETag"); br == gzipETag || br == identity {
+		t.Fatalf("br etag %s", br)
+	}
+
+	// gzip 请求携带未压缩响应的 ETag 不能返回 304
+	w := get(http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {identity}})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("identity etag on gzip request: %d %s", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	w = get(http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {gzipETag}})
+	if w.Code != http.StatusNotModified || w.Header().Get("ETag") != gzipETag {
+		t.Fatalf("gzip etag on gzip request: %d %s", w.Code, w.Header().Get("ETag"))
+	}
+	if w = get(http.Header{"If-None-Match": {gzipETag}}); w.Code != http.StatusOK {
+		t.Fatalf("gzip etag on identity request: %d", w.Code)
+	}
+
+	// Range 片段取自未压缩内容，If-Range 为压缩响应的 ETag 时返回完整的压缩响应
+	w = get(http.Header{"Accept-Encoding": {"gzip"}, "Range": {"bytes=0-9"}, "If-Range": {gzipETag}})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("If-Range with gzip etag: %d %s", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	w = get(http.Header{"Accept-Encoding": {"gzip"}, "Range": {"bytes=0-9"}, "If-Range": {identity}})
+	if w.Code != http.StatusPartialContent || w.Header().Get("Content-Encoding") != "" ||
+		w.Header().Get("ETag") != identity || w.Body.Len() != 10 {
+		t.Fatalf("If-Range with identity etag: %d %s %s", w.Code, w.Header().Get("Content-Encoding"), w.Header().Get("ETag"))
+	}
+}
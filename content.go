@@ -0,0 +1,68 @@
+package main
+
+import (
+	"hash/fnv"
+	"net"
+	"strconv"
+)
+
+// 响应体内容由 host + path + 对象版本号 作为种子确定性地生成，
+// 每个 URL 的内容都不同且可复现，客户端可以在本地按任意偏移重新生成期望内容进行校验，
+// 不需要源站下发校验和。
+
+// contentAlphabet 内容字符表，使用可打印字符便于日志输出和压缩
+const contentAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// contentHost 返回参与内容种子计算的主机名（去掉端口）
+func contentHost(host string) string {
+	if config.contentHost != "" {
+		host = config.contentHost
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// contentSeed 计算对象内容的种子
+func contentSeed(host, path string, version int64) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(host))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(version, 10)))
+	return h.Sum64()
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// fillContent 生成对象在偏移 off 处的 len(p) 个字节。
+// 每 8 个字节由 splitmix64(seed ^ 字序号) 决定，因此任意偏移都可以独立计算。
+func fillContent(seed uint64, off int64, p []byte) {
+	for i := 0; i < len(p); {
+		pos := off + int64(i)
+		word := splitmix64(seed ^ uint64(pos>>3))
+		for shift := pos & 7; shift < 8 && i < len(p); shift++ {
+			p[i] = contentAlphabet[(word>>(shift*8))&63]
+			i++
+		}
+	}
+}
+
+// genContent 生成对象的完整内容
+func genContent(seed uint64, size int) []byte {
+	b := make([]byte, size)
+	fillContent(seed, 0, b)
+	return b
+}
+
+// clientContentSeed 客户端按请求 URL 计算期望内容的种子，与源站使用相同的规则
+func clientContentSeed(urlPath string, version int64) uint64 {
+	return contentSeed(contentHost(config.host), urlPath, version)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFillContentAtOffset(t *testing.T) {
+	seed := contentSeed("test.com", "/path1.js", 0)
+	full := genContent(seed, 1000)
+	for _, off := range []int64{0, 1, 7, 8, 13, 500, 993} {
+		p := make([]byte, 1000-off)
+		fillContent(seed, off, p)
+		if !bytes.Equal(p, full[off:]) {
+			t.Errorf("fillContent at offset %d differs from full body", off)
+		}
+	}
+}
+
+func TestContentSeedUnique(t *testing.T) {
+	seeds := map[uint64]string{}
+	for _, k := range []struct {
+		host, path string
+		version    int64
+	}{
+		{"test.com", "/path1.js", 0},
+		{"test.com", "/path2.js", 0},
+		{"test.com", "/path1.js", 1},
+		{"other.com", "/path1.js", 0},
+	} {
+		s := contentSeed(k.host, k.path, k.version)
+		if prev, ok := seeds[s]; ok {
+			t.Errorf("seed collision between %s and %v", prev, k)
+		}
+		seeds[s] = k.host + k.path
+	}
+	a := genContent(contentSeed("test.com", "/path1.js", 0), 64)
+	b := genContent(contentSeed("test.com", "/path2.js", 0), 64)
+	if bytes.Equal(a, b) {
+		t.Error("different URLs produced identical content")
+	}
+}
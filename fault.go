@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 源站错误注入：按概率返回 404/500/502/503/504 等错误状态码，用于测试 CDN 的负缓存、回源重试和错误处理。
+// -fault 为全局概率，如 "404:0.01,503:0.02"；-fault-rules 按 URL 模式指定概率，
+// 如 "/live/* 502:0.2; .jpg 404:0.05"，第一个匹配的规则生效，没有匹配时使用全局概率。
+// 注入的错误响应带有 X-Press-Fault 头，便于区分源站注入的错误和 CDN 自身产生的错误。
+const faultHeaderName = "X-Press-Fault"
+
+// faultRate 一个错误状态码及其概率
+type faultRate struct {
+	status int
+	prob   float64
+}
+
+// faultRule 一条按 URL 模式的错误注入规则
+type faultRule struct {
+	pattern string
+	rates   []faultRate
+}
+
+type faultInjector struct {
+	global []faultRate
+	rules  []faultRule
+	bodies map[int][]byte // 状态码 -> 错误响应体
+}
+
+var faults *faultInjector
+
+// parseFaultRates 解析 "状态码:概率,..."，概率之和不超过 1
+func parseFaultRates(spec string) ([]faultRate, error) {
+	var rates []faultRate
+	sum := 0.0
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		code, p, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("错误注入概率应为 状态码:概率: %q", part)
+		}
+		status, err := strconv.Atoi(code)
+		if err != nil || status < 400 || status > 599 {
+			return nil, fmt.Errorf("无效的错误状态码: %q", code)
+		}
+		prob, err := strconv.ParseFloat(p, 64)
+		if err != nil || prob < 0 {
+			return nil, fmt.Errorf("无效的概率: %q", p)
+		}
+		sum += prob
+		rates = append(rates, faultRate{status, prob})
+	}
+	if sum > 1+1e-9 {
+		return nil, fmt.Errorf("错误注入概率之和 %.3f 超过 1", sum)
+	}
+	return rates, nil
+}
+
+// parseFaultRules 解析以 ";" 分隔的 "模式 状态码:概率,..." 规则
+func parseFaultRules(spec string) ([]faultRule, error) {
+	var rules []faultRule
+	for _, rule := range strings.Split(spec, ";") {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("错误注入规则应为 \"模式 状态码:概率,...\": %q", rule)
+		}
+		if _, err := path.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("无效的模式 %q: %v", fields[0], err)
+		}
+		rates, err := parseFaultRates(strings.Join(fields[1:], ""))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, faultRule{pattern: fields[0], rates: rates})
+	}
+	return rules, nil
+}
+
+// faultBody 生成指定大小的错误响应体
+func faultBody(status, size int) []byte {
+	line := []byte(fmt.Sprintf("cache_press injected fault: %d %s\n", status, http.StatusText(status)))
+	return bytes.Repeat(line, size/len(line)+1)[:size]
+}
+
+func newFaultInjector(global, rules string, bodySize int) (*faultInjector, error) {
+	f := &faultInjector{bodies: make(map[int][]byte)}
+	var err error
+	if f.global, err = parseFaultRates(global); err != nil {
+		return nil, err
+	}
+	if f.rules, err = parseFaultRules(rules); err != nil {
+		return nil, err
+	}
+	if len(f.global) == 0 && len(f.rules) == 0 {
+		return nil, nil
+	}
+	all := append([]faultRate(nil), f.global...)
+	for _, r := range f.rules {
+		all = append(all, r.rates...)
+	}
+	for _, r := range all {
+		f.bodies[r.status] = faultBody(r.status, bodySize)
+	}
+	return f, nil
+}
+
+// pick 按概率决定是否对该 URL 注入错误，返回状态码，0 表示不注入
+func (f *faultInjector) pick(urlPath string) int {
+	if f == nil {
+		return 0
+	}
+	rates := f.global
+	for _, r := range f.rules {
+		if matchURLPattern(r.pattern, urlPath) {
+			rates = r.rates
+			break
+		}
+	}
+	if len(rates) == 0 {
+		return 0
+	}
+	u := rand.Float64()
+	for _, r := range rates {
+		if u < r.prob {
+			return r.status
+		}
+		u -= r.prob
+	}
+	return 0
+}
+
+// setFaultHeaders 将已设置的响应头替换为错误响应的头，返回错误响应体
+func (f *faultInjector) setFaultHeaders(w http.ResponseWriter, status int) []byte {
+	h := w.Header()
+	for _, name := range []string{"ETag", "Last-Modified", "Cache-Control", "Expires", "Surrogate-Control",
+		"Accept-Ranges", "Content-Range", sliceMD5HeaderName, sliceManifestHeaderName} {
+		h.Del(name)
+	}
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	h.Set(faultHeaderName, strconv.Itoa(status))
+	if config.faultCacheControl != "" {
+		h.Set("Cache-Control", config.faultCacheControl)
+	}
+	if status == http.StatusServiceUnavailable && config.faultRetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(config.faultRetryAfter))
+	}
+	return f.bodies[status]
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFaultRates(t *testing.T) {
+	rates, err := parseFaultRates("404:0.1, 503:0.2")
+	if err != nil || len(rates) != 2 || rates[1].status != 503 || rates[1].prob != 0.2 {
+		t.Fatalf("got %v %v", rates, err)
+	}
+	for _, spec := range []string{"404", "200:0.1", "600:0.1", "404:x", "404:-1", "404:0.6,500:0.6"} {
+		if _, err := parseFaultRates(spec); err == nil {
+			t.Errorf("%q should be invalid", spec)
+		}
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	if f, err := newFaultInjector("", "", 100); f != nil || err != nil {
+		t.Fatalf("empty spec should disable injection: %v %v", f, err)
+	}
+
+	f, err := newFaultInjector("404:0.5", "/live/* 503:1; .jpg 500:0", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if s := f.pick("/live/a.ts"); s != 503 {
+			t.Fatalf("rule with probability 1 returned %d", s)
+		}
+		if s := f.pick("/a.jpg"); s != 0 {
+			t.Fatalf("rule with probability 0 returned %d", s)
+		}
+	}
+	n := 0
+	const total = 20000
+	for i := 0; i < total; i++ {
+		if f.pick("/a.js") == 404 {
+			n++
+		}
+	}
+	if got := float64(n) / total; math.Abs(got-0.5) > 0.02 {
+		t.Errorf("global 404 ratio %.3f, want 0.5", got)
+	}
+
+	config.faultCacheControl = "max-age=5"
+	config.faultRetryAfter = 3
+	defer func() { config.faultCacheControl, config.faultRetryAfter = "", 0 }()
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `"x"`)
+	body := f.setFaultHeaders(w, http.StatusServiceUnavailable)
+	if len(body) != 100 || w.Header().Get("ETag") != "" || w.Header().Get("Retry-After") != "3" ||
+		w.Header().Get("Cache-Control") != "max-age=5" || w.Header().Get(faultHeaderName) != "503" {
+		t.Errorf("fault response: %d bytes, headers %v", len(body), w.Header())
+	}
+}
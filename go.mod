@@ -3,17 +3,21 @@ module cache_press
 go 1.25.2
 
 require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/stretchr/testify v1.7.0
+	go.uber.org/ratelimit v0.3.1
 	golang.org/x/sys v0.39.0
 	golang.org/x/time v0.14.0
+	mosn.io/api v1.5.0
 )
 
 require (
-	github.com/andybalholm/brotli v1.2.0 // indirect
 	github.com/benbjohnson/clock v1.3.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/golang/protobuf v1.5.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rcrowley/go-metrics v0.0.0-20200313005456-10cdbea86bc0 // indirect
-	go.uber.org/ratelimit v0.3.1 // indirect
 	google.golang.org/protobuf v1.26.0-rc.1 // indirect
-	mosn.io/api v1.5.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 	mosn.io/pkg v1.6.0 // indirect
 )
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 头部一致性校验：源站在响应中带上它收到的请求头摘要 (X-Press-Req-Headers) 和它发出的响应头摘要
+// (X-Press-Resp-Headers)，客户端据此比较 发出的请求头 ↔ 源站收到的请求头、源站发出的响应头 ↔ 客户端收到的响应头。
+// 摘要格式为 "name:crc32,name:crc32"，请求头摘要前面带上 "id=<trace id>;"，
+// 只有 trace id 与本次请求一致（即本次请求确实回源）时才比较请求头。
+const (
+	reqHeadersDigestName  = "X-Press-Req-Headers"
+	respHeadersDigestName = "X-Press-Resp-Headers"
+)
+
+// defaultHdrCheckExclude 默认排除的逐跳头和通常由 CDN 改写的头
+const defaultHdrCheckExclude = "Connection,Keep-Alive,Transfer-Encoding,Te,Trailer,Upgrade,Proxy-*,Via,X-Forwarded-*,X-Real-Ip,Age,Date," +
+	reqHeadersDigestName + "," + respHeadersDigestName
+
+func headerValueCRC(v string) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(v))), 16)
+}
+
+// headerDigest 计算头部摘要，名称统一为小写并排序，多个值以逗号连接后计算 crc32。
+// host 不为空时作为 host 头参与摘要（Go 的 Request.Host 不在 Header 中）
+func headerDigest(h http.Header, host string, skip string) string {
+	values := make(map[string]string, len(h)+1)
+	for name, vs := range h {
+		if strings.EqualFold(name, skip) {
+			continue
+		}
+		values[strings.ToLower(name)] = strings.Join(vs, ",")
+	}
+	if host != "" {
+		values["host"] = host
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + ":" + headerValueCRC(values[name])
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseHeaderDigest 解析摘要，返回 小写名称 -> crc32
+func parseHeaderDigest(s string) map[string]string {
+	m := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		if name, crc, ok := strings.Cut(part, ":"); ok {
+			m[name] = crc
+		}
+	}
+	return m
+}
+
+// setRequestHeadersDigest 源站在响应中带上收到的请求头摘要
+func setRequestHeadersDigest(w http.ResponseWriter, r *http.Request, traceID string) {
+	w.Header().Set(reqHeadersDigestName, "id="+traceID+";"+headerDigest(r.Header, r.Host, ""))
+}
+
+// setResponseHeadersDigest 源站在发送响应头前带上响应头摘要，需在所有响应头设置完成后调用
+func setResponseHeadersDigest(w http.ResponseWriter) {
+	w.Header().Set(respHeadersDigestName, headerDigest(w.Header(), "", respHeadersDigestName))
+}
+
+// headerFilter 按 glob 模式（大小写不敏感）选择参与校验的头
+type headerFilter struct {
+	include []string
+	exclude []string
+}
+
+func splitGlobs(s string) []string {
+	var globs []string
+	for _, g := range strings.Split(s, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			globs = append(globs, strings.ToLower(g))
+		}
+	}
+	return globs
+}
+
+func newHeaderFilter(include, exclude string) (*headerFilter, error) {
+	f := &headerFilter{include: splitGlobs(include), exclude: splitGlobs(exclude)}
+	for _, g := range append(append([]string(nil), f.include...), f.exclude...) {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("无效的头部匹配模式 %q: %v", g, err)
+		}
+	}
+	return f, nil
+}
+
+func matchAnyGlob(globs []string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// match 判断小写头名称是否参与校验
+func (f *headerFilter) match(name string) bool {
+	return matchAnyGlob(f.include, name) && !matchAnyGlob(f.exclude, name)
+}
+
+// hdrMismatch 一次头部不一致
+type hdrMismatch struct {
+	Direction string `json:"direction"` // request: 客户端 -> 源站, response: 源站 -> 客户端
+	Name      string `json:"name"`
+	Kind      string `json:"kind"` // missing / changed / added
+	Value     string `json:"value,omitempty"`
+}
+
+// hdrChecker 客户端头部一致性校验
+type hdrChecker struct {
+	filter *headerFilter
+
+	mu     sync.Mutex
+	counts map[string]int64 // direction/name/kind -> 次数
+	logMu  sync.Mutex
+	log    *os.File
+}
+
+var headerChecker *hdrChecker
+
+func initHeaderChecker() {
+	if !config.hdrCheck {
+		return
+	}
+	filter, err := newHeaderFilter(config.hdrCheckInclude, config.hdrCheckExclude)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c := &hdrChecker{filter: filter, counts: make(map[string]int64), log: os.Stdout}
+	if config.hdrCheckLog != "" {
+		c.log, err = os.OpenFile(config.hdrCheckLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatal("打开头部校验日志失败: ", err)
+		}
+	}
+	headerChecker = c
+}
+
+// checkRequest 比较发出的请求头与源站收到的请求头，只在响应确实来自本次回源时比较
+func (c *hdrChecker) checkRequest(req *http.Request, resp *http.Response) []hdrMismatch {
+	id, digest, ok := strings.Cut(resp.Header.Get(reqHeadersDigestName), ";")
+	if !ok || id != "id="+req.Header.Get(config.ReqIDHdrName) {
+		return nil
+	}
+	received := parseHeaderDigest(digest)
+	sent := make(map[string]string, len(req.Header)+1)
+	for name, vs := range req.Header {
+		sent[strings.ToLower(name)] = strings.Join(vs, ",")
+	}
+	sent["host"] = req.Host
+
+	var mismatches []hdrMismatch
+	for name, v := range sent {
+		if !c.filter.match(name) {
+			continue
+		}
+		crc, ok := received[name]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, hdrMismatch{"request", name, "missing", v})
+		case crc != headerValueCRC(v):
+			mismatches = append(mismatches, hdrMismatch{"request", name, "changed", v})
+		}
+	}
+	for name := range received {
+		if _, ok := sent[name]; !ok && c.filter.match(name) {
+			mismatches = append(mismatches, hdrMismatch{"request", name, "added", ""})
+		}
+	}
+	return mismatches
+}
+
+// checkResponse 比较源站发出的响应头与客户端收到的响应头
+func (c *hdrChecker) checkResponse(resp *http.Response) []hdrMismatch {
+	digest := resp.Header.Get(respHeadersDigestName)
+	if digest == "" {
+		return nil
+	}
+	var mismatches []hdrMismatch
+	for name, crc := range parseHeaderDigest(digest) {
+		if !c.filter.match(name) {
+			continue
+		}
+		vs := resp.Header.Values(name)
+		switch {
+		case len(vs) == 0:
+			mismatches = append(mismatches, hdrMismatch{"response", name, "missing", ""})
+		case crc != headerValueCRC(strings.Join(vs, ",")):
+			mismatches = append(mismatches, hdrMismatch{"response", name, "changed", strings.Join(vs, ",")})
+		}
+	}
+	return mismatches
+}
+
+// check 校验一次请求的请求头和响应头，返回不一致的数量
+func (c *hdrChecker) check(req *http.Request, resp *http.Response) int {
+	mismatches := append(c.checkRequest(req, resp), c.checkResponse(resp)...)
+	if len(mismatches) == 0 {
+		return 0
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		if mismatches[i].Direction != mismatches[j].Direction {
+			return mismatches[i].Direction < mismatches[j].Direction
+		}
+		return mismatches[i].Name < mismatches[j].Name
+	})
+
+	c.mu.Lock()
+	for _, m := range mismatches {
+		c.counts[m.Direction+"/"+m.Name+"/"+m.Kind]++
+	}
+	c.mu.Unlock()
+
+	if rand.Float64() < config.hdrCheckSample {
+		line, _ := json.Marshal(struct {
+			Time       string        `json:"time"`
+			TraceID    string        `json:"trace_id"`
+			URL        string        `json:"url"`
+			Cache      string        `json:"cache"`
+			Mismatches []hdrMismatch `json:"mismatches"`
+		}{time.Now().Format(time.RFC3339Nano), req.Header.Get(config.ReqIDHdrName), req.URL.RequestURI(),
+			resp.Header.Get("X-Cache"), mismatches})
+		c.logMu.Lock()
+		c.log.Write(append(line, '\n'))
+		c.logMu.Unlock()
+	}
+	return len(mismatches)
+}
+
+// snapshot 返回按 方向/头名称/类型 统计的不一致次数
+func (c *hdrChecker) snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := make(map[string]int64, len(c.counts))
+	for k, v := range c.counts {
+		m[k] = v
+	}
+	return m
+}
+
+func (c *hdrChecker) print() {
+	counts := c.snapshot()
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Printf("头部不一致统计 (方向/头名称/类型):\n")
+	for _, k := range keys {
+		fmt.Printf("  %s: %d\n", k, counts[k])
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func TestHeaderCheck(t *testing.T) {
+	config.ReqIDHdrName = "X-Request-ID"
+	filter, err := newHeaderFilter("*", defaultHdrCheckExclude+",X-Ignored-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &hdrChecker{filter: filter, counts: make(map[string]int64)}
+
+	req := httptest.NewRequest(http.MethodGet, "http://test.com/path1.js", nil)
+	req.Host = "test.com"
+	req.Header.Set("X-Request-ID", "trace-1")
+	req.Header.Set("User-Agent", "PressureTestClient-0")
+	req.Header.Set("X-Custom", "a")
+	req.Header.Set("X-Ignored-Foo", "1")
+
+	// 模拟 CDN 改写 User-Agent、删除 X-Custom、增加 X-Cdn 后回源
+	received := req.Clone(req.Context())
+	received.Header.Set("User-Agent", "cdn")
+	received.Header.Del("X-Custom")
+	received.Header.Del("X-Ignored-Foo")
+	received.Header.Set("X-Cdn", "1")
+	received.Header.Set("Via", "cdn")
+
+	w := httptest.NewRecorder()
+	setRequestHeadersDigest(w, received, "trace-1")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Press-Version", "0")
+	setResponseHeadersDigest(w)
+
+	// 模拟 CDN 改写 Content-Type
+	resp := &http.Response{Header: w.Header().Clone()}
+	resp.Header.Set("Content-Type", "text/plain")
+	resp.Header.Set("X-Cache", "MISS")
+
+	var got []string
+	for _, m := range append(c.checkRequest(req, resp), c.checkResponse(resp)...) {
+		got = append(got, m.Direction+"/"+m.Name+"/"+m.Kind)
+	}
+	sort.Strings(got)
+	want := []string{"request/user-agent/changed", "request/x-cdn/added", "request/x-custom/missing", "response/content-type/changed"}
+	if len(got) != len(want) {
+		t.Fatalf("mismatches = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("mismatches = %v, want %v", got, want)
+		}
+	}
+
+	// 缓存命中时请求头摘要属于其他请求，不比较请求头
+	req.Header.Set("X-Request-ID", "trace-2")
+	if ms := c.checkRequest(req, resp); len(ms) != 0 {
+		t.Errorf("request headers compared for a response of another request: %v", ms)
+	}
+}
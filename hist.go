@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"time"
+)
+
+// 延迟直方图采用对数线性分桶（与 HdrHistogram 相同的思路）：
+// 小于 2^histSubBits 微秒的值每微秒一个桶，更大的值在每个 2 的幂区间内再均分为 histHalf 个桶，
+// 相对误差不超过 1/histHalf (约 1.6%)。直方图之间可以直接按桶相加合并。
+const (
+	histSubBits = 7
+	histSub     = 1 << histSubBits
+	histHalf    = histSub / 2
+	histBuckets = (64-histSubBits+1)*histHalf + histHalf
+)
+
+// latencyHist 延迟直方图，单位为微秒
+type latencyHist struct {
+	counts [histBuckets]int64
+	total  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+func histIndex(v int64) int {
+	if v < histSub {
+		return int(v)
+	}
+	e := bits.Len64(uint64(v)) - histSubBits
+	return e*histHalf + int(v>>e)
+}
+
+// histValue 返回桶内的中间值
+func histValue(idx int) int64 {
+	if idx < histSub {
+		return int64(idx)
+	}
+	e := idx/histHalf - 1
+	m := int64(idx - e*histHalf)
+	lower := m << e
+	return lower + (int64(1)<<e)/2
+}
+
+func (h *latencyHist) record(d time.Duration) {
+	v := d.Microseconds()
+	if v < 0 {
+		v = 0
+	}
+	h.counts[histIndex(v)]++
+	if h.total == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.total++
+	h.sum += v
+}
+
+func (h *latencyHist) merge(o *latencyHist) {
+	if o.total == 0 {
+		return
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	if h.total == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+	h.total += o.total
+	h.sum += o.sum
+}
+
+func (h *latencyHist) reset() {
+	*h = latencyHist{}
+}
+
+// quantile 返回第 q (0-1) 分位的延迟
+func (h *latencyHist) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(h.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var cum int64
+	for i, c := range h.counts {
+		cum += c
+		if cum >= rank {
+			v := histValue(i)
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return time.Duration(v) * time.Microsecond
+		}
+	}
+	return time.Duration(h.max) * time.Microsecond
+}
+
+func (h *latencyHist) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum/h.total) * time.Microsecond
+}
+
+func (h *latencyHist) String() string {
+	if h.total == 0 {
+		return "无数据"
+	}
+	return fmt.Sprintf("p50=%v p90=%v p99=%v p99.9=%v 平均=%v 最小=%v 最大=%v (n=%d)",
+		h.quantile(0.5), h.quantile(0.9), h.quantile(0.99), h.quantile(0.999),
+		h.mean(), time.Duration(h.min)*time.Microsecond, time.Duration(h.max)*time.Microsecond, h.total)
+}
+
+// latencyStats 按缓存命中/未命中分别统计首包时间和完整响应时间，下标 0 为未命中，1 为命中
+type latencyStats struct {
+	firstByte [2]latencyHist
+	total     [2]latencyHist
+}
+
+func (s *latencyStats) record(r reqStatInfo) {
+	i := 0
+	if r.cacheHit {
+		i = 1
+	}
+	s.firstByte[i].record(r.firstByteTime)
+	s.total[i].record(r.respTime)
+}
+
+func (s *latencyStats) merge(o *latencyStats) {
+	for i := range s.firstByte {
+		s.firstByte[i].merge(&o.firstByte[i])
+		s.total[i].merge(&o.total[i])
+	}
+}
+
+func (s *latencyStats) reset() {
+	for i := range s.firstByte {
+		s.firstByte[i].reset()
+		s.total[i].reset()
+	}
+}
+
+func (s *latencyStats) count() int64 {
+	return s.firstByte[0].total + s.firstByte[1].total
+}
+
+func (s *latencyStats) hits() int64 {
+	return s.firstByte[1].total
+}
+
+// all 返回合并命中与未命中后的首包和响应时间直方图
+func (s *latencyStats) all() (firstByte, total *latencyHist) {
+	firstByte, total = &latencyHist{}, &latencyHist{}
+	for i := range s.firstByte {
+		firstByte.merge(&s.firstByte[i])
+		total.merge(&s.total[i])
+	}
+	return
+}
+
+func (s *latencyStats) print(prefix string) {
+	firstByte, total := s.all()
+	fmt.Printf("%s首包时间(全部):   %s\n", prefix, firstByte)
+	fmt.Printf("%s首包时间(命中):   %s\n", prefix, &s.firstByte[1])
+	fmt.Printf("%s首包时间(未命中): %s\n", prefix, &s.firstByte[0])
+	fmt.Printf("%s响应时间(全部):   %s\n", prefix, total)
+	fmt.Printf("%s响应时间(命中):   %s\n", prefix, &s.total[1])
+	fmt.Printf("%s响应时间(未命中): %s\n", prefix, &s.total[0])
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistQuantile(t *testing.T) {
+	var h latencyHist
+	for v := 1; v <= 100000; v++ {
+		h.record(time.Duration(v) * time.Microsecond)
+	}
+	for _, q := range []float64{0.5, 0.9, 0.99, 0.999} {
+		want := q * 100000
+		got := float64(h.quantile(q).Microseconds())
+		if math.Abs(got-want)/want > 0.02 {
+			t.Errorf("quantile(%v) = %vus, want about %vus", q, got, want)
+		}
+	}
+	if h.min != 1 || h.max != 100000 || h.total != 100000 {
+		t.Errorf("unexpected min/max/total %d/%d/%d", h.min, h.max, h.total)
+	}
+}
+
+func TestLatencyHistMerge(t *testing.T) {
+	var a, b, all latencyHist
+	for v := 0; v < 1000; v++ {
+		d := time.Duration(v*v) * time.Microsecond
+		all.record(d)
+		if v%2 == 0 {
+			a.record(d)
+		} else {
+			b.record(d)
+		}
+	}
+	a.merge(&b)
+	if a != all {
+		t.Error("merged histogram differs from histogram of all values")
+	}
+}
+
+func TestHistIndexMonotonic(t *testing.T) {
+	prev := -1
+	for v := int64(0); v < 1<<20; v += 7 {
+		idx := histIndex(v)
+		if idx < prev {
+			t.Fatalf("histIndex(%d) = %d is less than previous %d", v, idx, prev)
+		}
+		if mid := histValue(idx); math.Abs(float64(mid-v)) > float64(v)/histHalf+1 {
+			t.Fatalf("histValue(histIndex(%d)) = %d, error too large", v, mid)
+		}
+		prev = idx
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 负载模型：
+//   - closed: 每个连接协程限速后同步发送请求，CDN 变慢时实际发送速率随之下降（默认，原有行为）
+//   - open:   按到达过程（constant 等间隔 / poisson 指数间隔）调度请求，与请求是否完成无关。
+//     延迟从计划发送时间开始计算，避免协调遗漏 (coordinated omission)；
+//     同时在途请求数受 -max-inflight 限制，达到上限时请求等待空位。实际发送时间比计划时间晚
+//     lateSendTolerance 以上的请求（包括等待空位和阻塞之后集中补发的请求）计为未按时发送
+const (
+	loadModelClosed = "closed"
+	loadModelOpen   = "open"
+)
+
+// lateSendTolerance 开环模式下实际发送时间晚于计划时间多少以上计为未按时发送，
+// 容忍定时器和协程调度的正常抖动
+const lateSendTolerance = 2 * time.Millisecond
+
+// lateRequests 开环模式下未能按计划时间发送的请求数
+var lateRequests int64
+
+// sendLag 开环模式下实际发送时间相对计划时间的延迟，只由调度协程写入，调度结束后读取
+var sendLag latencyHist
+
+// arrivalProcess 请求到达过程，返回到下一个请求之间以"请求数"计的间隔，
+// 实际时间间隔为该值除以 QPS（QPS 变化时按 advanceArrival 累计）
+type arrivalProcess interface {
+	work() float64
+}
+
+type constantArrival struct{}
+
+func (constantArrival) work() float64 {
+	return 1
+}
+
+// poissonArrival 泊松到达，间隔服从均值为 1/qps 的指数分布
+type poissonArrival struct {
+	rng *rand.Rand
+}
+
+func (p poissonArrival) work() float64 {
+	return p.rng.ExpFloat64()
+}
+
+func parseArrival(s string) (arrivalProcess, error) {
+	switch s {
+	case "constant":
+		return constantArrival{}, nil
+	case "poisson":
+		return poissonArrival{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}, nil
+	}
+	return nil, fmt.Errorf("无效的到达过程 %q，应为 constant 或 poisson", s)
+}
+
+// recordSend 记录一次发送相对计划时间的延迟，超过 lateSendTolerance 的计为未按时发送
+func recordSend(lag time.Duration) {
+	sendLag.record(lag)
+	if lag > lateSendTolerance {
+		atomic.AddInt64(&lateRequests, 1)
+		if clientMetrics.recording() {
+			clientMetrics.late.inc()
+		}
+	}
+}
+
+// openLoopMaxInflight 开环模式的最大在途请求数，未指定时使用 -conns
+func openLoopMaxInflight() int {
+	if config.maxInflight > 0 {
+		return config.maxInflight
+	}
+	return config.conns
+}
+
+// runOpenLoop 按到达过程和目标 QPS 调度请求直到 startTime 之后 duration 结束，然后等待在途请求完成
+func runOpenLoop(baseURL string, startTime time.Time, duration time.Duration, qpsAt func(time.Time) float64) {
+	arrival, _ := parseArrival(config.arrival)
+	maxInflight := openLoopMaxInflight()
+
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}
+	// 空闲的请求编号，同时作为在途请求数的信号量
+	slots := make(chan int, maxInflight)
+	for i := 0; i < maxInflight; i++ {
+		slots <- i
+	}
+
+	var wg sync.WaitGroup
+	end := startTime.Add(duration)
+	intended := advanceArrival(startTime, end, arrival.work(), qpsAt)
+	for intended.Before(end) {
+		if d := time.Until(intended); d > 0 {
+			time.Sleep(d)
+		}
+
+		// 在途请求已满时等待空位，等待时间计入该请求的延迟；之后积压的请求集中补发，同样晚于计划时间
+		id := <-slots
+		recordSend(time.Since(intended))
+
+		wg.Add(1)
+		go func(id int, intended time.Time) {
+			defer wg.Done()
+			url := urlPopularity.nextURL(baseURL)
+			doRequest(client, id, http.MethodGet, url, intended)
+			slots <- id
+		}(id, intended)
+
+		intended = advanceArrival(intended, end, arrival.work(), qpsAt)
+	}
+	wg.Wait()
+}
+
+// printOpenLoopStats 输出开环模式的发送统计
+func printOpenLoopStats() {
+	if runProfile != nil || config.mode == "search" {
+		fmt.Printf("负载模型: 开环 (%s 到达, 目标 QPS 随时间变化, 最大在途请求数=%d)\n", config.arrival, openLoopMaxInflight())
+	} else {
+		fmt.Printf("负载模型: 开环 (%s 到达, QPS=%d, 最大在途请求数=%d)\n", config.arrival, config.qps, openLoopMaxInflight())
+	}
+	fmt.Printf("未按时发送请求数: %d\n", atomic.LoadInt64(&lateRequests))
+	fmt.Printf("发送延迟 (实际发送 - 计划发送): %s\n", &sendLag)
+}
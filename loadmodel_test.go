@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestArrivalInterval(t *testing.T) {
+	a, err := parseArrival("constant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	end := start.Add(time.Hour)
+	qps := func(time.Time) float64 { return 200 }
+	if d := advanceArrival(start, end, a.work(), qps).Sub(start); d != 5*time.Millisecond {
+		t.Fatalf("constant interval %v", d)
+	}
+
+	p, err := parseArrival("poisson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 20000
+	var sum float64
+	for i := 0; i < n; i++ {
+		sum += p.work()
+	}
+	if mean := sum / n; math.Abs(mean-1) > 0.05 {
+		t.Fatalf("poisson mean work %.3f, want ~1", mean)
+	}
+
+	if _, err := parseArrival("burst"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRecordSendLate(t *testing.T) {
+	t.Cleanup(resetClientStats)
+	resetClientStats()
+	oldMetrics := clientMetrics
+	t.Cleanup(func() { clientMetrics = oldMetrics })
+	clientMetrics = &clientMetricSet{late: newCounterVec("test_late_requests_total", "test")}
+
+	// 按时发送和调度抖动不计入，阻塞之后补发的请求计入
+	for _, lag := range []time.Duration{0, time.Millisecond, lateSendTolerance, 3 * lateSendTolerance, time.Second} {
+		recordSend(lag)
+	}
+	if lateRequests != 2 || sendLag.total != 5 {
+		t.Fatalf("late=%d lag samples=%d", lateRequests, sendLag.total)
+	}
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	//	"bufio"
-	"bytes"
 	"flag"
 	"fmt"
 	"log"
@@ -12,7 +11,7 @@ import (
 	"sync/atomic"
 	"syscall"
 
-	//	"os"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -30,14 +29,51 @@ type Config struct {
 	duration   time.Duration
 	tickerDump time.Duration
 
+	// 负载模型 - 仅客户端使用
+	loadModel   string // closed / open
+	arrival     string // 开环模式的到达过程: constant / poisson
+	maxInflight int    // 开环模式的最大在途请求数
+
+	// 饱和点搜索 - 仅搜索模式使用
+	searchMin       int
+	searchMax       int
+	searchStep      int
+	searchPrecision int
+	searchDwell     time.Duration
+	sloP99FirstByte time.Duration
+	sloErrorRate    float64
+	sloHitRatio     float64
+
+	// 预填充与预热 - 仅客户端和搜索模式使用
+	prefill    string
+	prefillQPS int
+	warmup     time.Duration
+
+	// URL 状态文件 - 仅客户端和搜索模式使用
+	urlState     string
+	urlStateSave bool
+
+	// 负载曲线 - 仅客户端使用
+	loadProfile     string
+	loadProfileFile string
+
+	// Prometheus 指标监听地址，为空时不启用
+	metricsAddr string
+
+	// 机器可读的报告 - 仅客户端使用
+	reportJSON    string
+	timeSeriesCSV string
+
 	// 响应大小配置 - 仅客户端使用
 	respSizeStr   string
 	respSizeRange []int
 	diskRatio     float64
+	sizeDistStr   string
 
 	// CDN命中率配置 - 仅客户端使用
 	hitRatio            float64
 	urlCount            int
+	popularity          string
 	ignoreErr           bool
 	deferStart          int
 	delayRespHdr        int
@@ -46,7 +82,11 @@ type Config struct {
 	delayRespBodyRandom int
 
 	ReqIDHdrName string
+	contentHost  string
 	chunkResp    float64
+	chunkSize    string // chunked 响应的块大小，固定值或区间
+	chunkFlush   bool   // 每块之后 flush
+	chunkTrailer bool   // 在 trailer 中返回响应体 MD5
 	CloseConn    float64
 
 	// 响应体缓存配置 - 仅服务器使用
@@ -58,6 +98,57 @@ type Config struct {
 	// 测试MD5校验失败 - 仅客户端使用
 	testMD5Failure bool
 
+	// 响应体内容校验 - 仅客户端使用
+	verifyBody bool
+
+	// 分片MD5校验
+	sliceSize    int  // 分片大小，0 表示不返回分片摘要 - 仅服务器使用
+	sliceMD5Max  int  // 响应头中直接返回的最大分片数，超过时返回分片清单路径 - 仅服务器使用
+	verifySlices bool // 客户端校验分片MD5 - 仅客户端使用
+
+	// 头部一致性校验
+	hdrDigest       bool    // 源站返回请求头/响应头摘要 - 仅服务器使用
+	hdrCheck        bool    // 客户端校验头部一致性 - 仅客户端使用
+	hdrCheckInclude string  // 参与校验的头，逗号分隔的 glob 模式
+	hdrCheckExclude string  // 排除的头，逗号分隔的 glob 模式
+	hdrCheckLog     string  // 不一致采样日志文件，为空时输出到标准输出
+	hdrCheckSample  float64 // 不一致采样比例
+
+	// 客户端失败日志 - 仅客户端使用
+	failureLog string
+
+	// 读取空闲超时，发现挂起的响应 - 仅客户端使用
+	readIdleTimeout time.Duration
+
+	// 缓存校验头 - 仅服务器使用
+	etag         string // strong / weak / none
+	lastModified bool
+
+	// 缓存策略
+	cachePolicy     string // 缓存策略规则 - 仅服务器使用
+	cachePolicyFile string // 缓存策略规则文件 - 仅服务器使用
+	cacheMix        string // 按比例在 URL 中指定规则编号 - 仅客户端使用
+
+	// 错误注入 - 仅服务器使用
+	fault             string // 全局错误状态码概率
+	faultRules        string // 按 URL 模式的错误状态码概率
+	faultBodySize     int    // 错误响应体大小
+	faultCacheControl string // 错误响应的 Cache-Control
+	faultRetryAfter   int    // 503 响应的 Retry-After 秒数
+
+	// 响应体中途故障 - 仅服务器使用
+	bodyFault   string // 故障类型概率
+	bodyFaultAt string // 故障前写出的响应体比例 (百分比或区间)
+
+	// 响应体限速 - 仅服务器使用
+	bodyRate       string        // 每个响应的速率 (字节/秒)，固定值或区间
+	bodyRateGlobal int64         // 所有响应共享的带宽上限 (字节/秒)
+	bodyJitter     time.Duration // 每次写出之间的随机等待上限
+
+	// 源站访问日志 - 仅服务器使用
+	accessLog       string // 访问日志文件，"-" 为标准输出，为空时不记录
+	accessLogBuffer int    // 异步写入队列长度
+
 	// 持久连接控制 - 仅服务器使用
 	keepAliveProb          float64 // Connection头为keep-alive的概率 (0.0-1.0)
 	closeConnAfterBodyProb float64 // 发完body后主动关闭连接的概率 (0.0-1.0)
@@ -67,6 +158,11 @@ type Config struct {
 	maxIdleConnsPerHost int
 	idleConnTimeout     time.Duration
 
+	// 访问日志回放 - 仅回放模式使用
+	replayFile   string
+	replayFormat string
+	replaySpeed  float64
+
 	// 客户端主动断开连接控制
 	clientSendCloseProb     float64 // 发送完请求后主动断开连接的概率 (0.0-1.0)
 	clientRecvHalfCloseProb float64 // 接收响应body一半时主动断开连接的概率 (0.0-1.0)
@@ -77,6 +173,7 @@ type reqStatInfo struct {
 	respTime      time.Duration
 	firstByteTime time.Duration
 	cacheHit      bool
+	stage         int // 负载曲线中请求开始时所处的阶段
 }
 
 var config Config
@@ -120,49 +217,128 @@ func initTransport() {
 		},
 	}
 
-	// 更新 transport 使用自定义 dialer
-	transport.DialContext = customDialer.DialContext
+	// 更新 transport 使用自定义 dialer，并统计活跃连接数
+	transport.DialContext = clientMetrics.dialContext(customDialer.DialContext)
 }
 
 func init() {
-	flag.StringVar(&config.mode, "mode", "server", "运行模式: server/client")
+	flag.StringVar(&config.mode, "mode", "server", "运行模式: server/client/replay/search")
 	flag.IntVar(&config.port, "port", 8080, "服务器端口")
 	flag.StringVar(&config.host, "host", "localhost", "服务器主机名或IP")
 	flag.StringVar(&config.addr, "addr", "", "服务器完整地址 (格式: host:port)，如果设置了此参数则忽略host和port)")
 	flag.IntVar(&config.conns, "conns", 10, "并发连接数")
 	flag.IntVar(&config.qps, "qps", 100, "QPS限制")
-	flag.DurationVar(&config.duration, "duration", 30*time.Second, "压测持续时间")
+	flag.DurationVar(&config.duration, "duration", 30*time.Second, "压测持续时间，回放模式下只在显式指定时限制回放时长")
 	flag.DurationVar(&config.tickerDump, "ticker-dump", 5*time.Second, "定时输出统计信息间隔")
+	flag.StringVar(&config.loadModel, "load-model", loadModelClosed, "负载模型: closed 每个连接限速后同步请求 | open 按到达过程发送，延迟从计划发送时间计算 (仅客户端模式)")
+	flag.StringVar(&config.arrival, "arrival", "constant", "开环模式的请求到达过程: constant 等间隔 | poisson 泊松到达，平均速率为 -qps (仅客户端模式)")
+	flag.IntVar(&config.searchMin, "search-min", 100, "饱和点搜索的最低 QPS (仅搜索模式)")
+	flag.IntVar(&config.searchMax, "search-max", 10000, "饱和点搜索的最高 QPS (仅搜索模式)")
+	flag.IntVar(&config.searchStep, "search-step", 0, "按该步长从 -search-min 递增 QPS，0 表示二分查找 (仅搜索模式)")
+	flag.IntVar(&config.searchPrecision, "search-precision", 100, "二分查找在通过与不通过的 QPS 相差不超过该值时结束 (仅搜索模式)")
+	flag.DurationVar(&config.searchDwell, "search-dwell", 30*time.Second, "每个 QPS 级别的压测时长 (仅搜索模式)")
+	flag.DurationVar(&config.sloP99FirstByte, "slo-p99-first-byte", 0, "SLO: 首包时间 p99 上限，0 表示不检查 (仅搜索模式)")
+	flag.Float64Var(&config.sloErrorRate, "slo-error-rate", 0.01, "SLO: 错误率上限 (传输错误、非 2xx、内容校验失败)，0 表示不检查 (仅搜索模式)")
+	flag.Float64Var(&config.sloHitRatio, "slo-hit-ratio", 0, "SLO: 缓存命中率下限，0 表示不检查 (仅搜索模式)")
+	flag.StringVar(&config.prefill, "prefill", "", "正式压测前按顺序请求 id 为 1..N 的 URL 预填充缓存，值为 N 或 hot (热度模型的热点集合)，不计入统计 (仅客户端模式)")
+	flag.IntVar(&config.prefillQPS, "prefill-qps", 1000, "预填充的 QPS (仅客户端模式)")
+	flag.DurationVar(&config.warmup, "warmup", 0, "预填充之后、正式压测之前按 -qps 预热的时长，不计入统计 (仅客户端模式)")
+	flag.StringVar(&config.urlState, "url-state", "", "URL 状态文件: 启动时加载已发出的 URL (id、大小、版本号、最后访问时间) 和 id 计数器，结束时保存 (仅客户端模式)")
+	flag.BoolVar(&config.urlStateSave, "url-state-save", true, "结束时保存 -url-state，多台机器共用同一个状态文件时可只让一台保存 (仅客户端模式)")
+	flag.StringVar(&config.loadProfile, "load-profile", "", "负载曲线，以 ; 分隔的阶段: const QPS 时长 | ramp 起始 结束 时长 | step 起始 结束 步长 每步时长 | "+
+		"spike 基础 峰值 周期 峰值时长 时长 | sine 最低 最高 周期 时长，设置后忽略 -qps 和 -duration (仅客户端模式)")
+	flag.StringVar(&config.loadProfileFile, "load-profile-file", "", "负载曲线文件，每行一个阶段，格式同 -load-profile，# 开头为注释 (仅客户端模式)")
+	flag.IntVar(&config.maxInflight, "max-inflight", 0, "开环模式的最大在途请求数，达到上限时请求等待空位，0 表示使用 -conns (仅客户端模式)")
+	flag.StringVar(&config.metricsAddr, "metrics-addr", "", "Prometheus 指标监听地址 (如 :9100)，提供 /metrics，为空时不启用")
+	flag.StringVar(&config.reportJSON, "report-json", "", "结束时将配置、总数、命中率、错误分类和延迟分位写入该 JSON 文件")
+	flag.StringVar(&config.timeSeriesCSV, "timeseries-csv", "", "每个统计周期向该 CSV 文件写入一行时间序列数据")
 
 	// 响应大小配置 - 仅客户端使用
 	flag.StringVar(&config.respSizeStr, "resp-size", "1024", "响应大小，格式: 单个数字或范围 [min,max]")
 	flag.Float64Var(&config.diskRatio, "disk-ratio", 0.5, "小响应体比例 (0.0-1.0)")
+	flag.StringVar(&config.sizeDistStr, "size-dist", "", "对象大小分布，为空时使用 -resp-size 与 -disk-ratio。"+
+		"格式: fixed:N | uniform:min,max | lognormal:中位数,sigma[,min,max] | pareto:alpha,min,max | "+
+		"buckets:size:weight,... | empirical:文件.csv (每行 size,count)")
 
 	// CDN命中率配置 - 仅客户端使用
 	flag.Float64Var(&config.hitRatio, "hit-ratio", 0.5, "CDN命中率 (0.0-1.0)")
 	flag.IntVar(&config.urlCount, "url-count", 1000000, "总URL数量")
+	flag.StringVar(&config.popularity, "popularity", "ratio", "URL 访问热度模型: ratio (按 -hit-ratio) | zipf:s | hotcold:热点比例,热点请求比例 | "+
+		"moving:热点比例,热点请求比例,移动间隔[,步长]")
 	flag.BoolVar(&config.ignoreErr, "ignore-err", false, "忽略错误")
 	flag.IntVar(&config.deferStart, "defer-start", 0, "延迟启动时间(秒)")
 	flag.IntVar(&config.delayRespHdr, "delay-resp-hdr", 0, "延迟响应头时间(毫秒)")
 	flag.IntVar(&config.delayRespHdrRandom, "delay-resp-hdr-random", 0, "延迟响应头随机时间(毫秒)")
-	flag.IntVar(&config.delayRespBody, "delay-resp-body", 0, "延迟响应体时间(毫秒)")
+	flag.IntVar(&config.delayRespBody, "delay-resp-body", 0, "发出响应头后延迟发送响应体的时间(毫秒)")
 	flag.IntVar(&config.delayRespBodyRandom, "delay-resp-body-random", 0, "延迟响应体随机时间(毫秒)")
 	flag.Float64Var(&config.chunkResp, "chunk-resp", 0.0, "分块响应比例 (0.0-1.0)")
+	flag.StringVar(&config.chunkSize, "chunk-size", "8192", "分块响应每块的大小(字节)，固定值如 8192 或区间如 1024-16384 (仅服务器模式)")
+	flag.BoolVar(&config.chunkFlush, "chunk-flush", false, "分块响应每块之后立即 flush (仅服务器模式)")
+	flag.BoolVar(&config.chunkTrailer, "chunk-trailer", false, "分块响应在 trailer "+chunkTrailerName+" 中返回响应体 MD5，客户端会校验 (仅服务器模式)")
 	flag.Float64Var(&config.CloseConn, "client-close-conn-prob", 0.0, "请求后关闭连接比例 (0.0-1.0)")
 	flag.StringVar(&config.ReqIDHdrName, "req-id-hdr-name", "X-Request-ID", "请求ID头名称")
+	flag.StringVar(&config.contentHost, "content-host", "", "生成响应体内容时使用的主机名，为空时使用请求的 Host (CDN 改写回源 Host 时客户端和服务端需设置为相同值)")
 	flag.BoolVar(&config.cacheResp, "cache-resp", true, "启用响应体缓存 (仅服务器模式)")
 	flag.BoolVar(&config.enableMD5, "enable-md5", false, "启用MD5校验 (仅服务器模式)")
 	flag.BoolVar(&config.testMD5Failure, "test-md5-failure", false, "测试MD5校验失败 (仅客户端模式)")
+	flag.BoolVar(&config.verifyBody, "verify-body", false, "按源站确定性生成规则逐字节校验响应体 (仅客户端模式)")
+	flag.IntVar(&config.sliceSize, "slice-size", 0, "按该大小(字节)切分对象并返回每个分片的MD5，0 表示不返回 (仅服务器模式)")
+	flag.IntVar(&config.sliceMD5Max, "slice-md5-max", 64, "分片数不超过该值时在响应头中直接返回分片MD5，否则返回分片清单路径 (仅服务器模式)")
+	flag.BoolVar(&config.verifySlices, "verify-slices", false, "根据源站返回的分片MD5校验收到的完整分片，支持跨分片的 Range 响应 (仅客户端模式，源站需设置 -slice-size)")
+
+	// 头部一致性校验
+	flag.BoolVar(&config.hdrDigest, "hdr-digest", false, "在响应中返回收到的请求头摘要和发出的响应头摘要，供客户端做头部一致性校验 (仅服务器模式)")
+	flag.BoolVar(&config.hdrCheck, "hdr-check", false, "根据源站返回的头部摘要校验请求头和响应头一致性 (仅客户端模式，源站需开启 -hdr-digest)")
+	flag.StringVar(&config.hdrCheckInclude, "hdr-check-include", "*", "参与头部校验的头名称，逗号分隔，支持 glob 模式")
+	flag.StringVar(&config.hdrCheckExclude, "hdr-check-exclude", defaultHdrCheckExclude, "排除头部校验的头名称，逗号分隔，支持 glob 模式")
+	flag.StringVar(&config.hdrCheckLog, "hdr-check-log", "", "头部不一致采样日志文件 (JSON 行)，为空时输出到标准输出")
+	flag.Float64Var(&config.hdrCheckSample, "hdr-check-sample", 0.01, "头部不一致写入日志的采样比例 (0.0-1.0)")
 
 	// 连接池配置 - 仅客户端使用
 	flag.IntVar(&config.maxIdleConns, "max-idle-conns", 2000, "最大空闲连接数")
 	flag.IntVar(&config.maxIdleConnsPerHost, "max-idle-conns-per-host", 1000, "每个主机最大空闲连接数")
 	flag.DurationVar(&config.idleConnTimeout, "idle-conn-timeout", 100*time.Second, "空闲连接超时时间")
 
+	// 缓存校验头
+	flag.StringVar(&config.etag, "etag", "strong", "ETag 类型: strong / weak / none，由 URL 和版本号确定 (仅服务器模式)")
+	flag.BoolVar(&config.lastModified, "last-modified", true, "返回由 URL 和版本号确定的 Last-Modified 并支持 If-Modified-Since (仅服务器模式)")
+
+	// 缓存策略
+	flag.StringVar(&config.cachePolicy, "cache-policy", "", "缓存策略规则，\"模式 指令,...\" 以 ; 分隔，如 \".js max-age=60,s-maxage=300; /live/* no-store; * max-age=10,expires=10,surrogate-max-age=600\" (仅服务器模式)")
+	flag.StringVar(&config.cachePolicyFile, "cache-policy-file", "", "缓存策略规则文件，每行一条规则，# 开头为注释，规则在 -cache-policy 之前 (仅服务器模式)")
+	flag.StringVar(&config.cacheMix, "cache-mix", "", "按比例在 URL 中指定源站缓存策略规则编号 (_c<n>)，如 \"1:0.7,2:0.3\"，剩余比例按模式匹配 (仅客户端模式)")
+
+	// 错误注入
+	flag.StringVar(&config.fault, "fault", "", "按概率返回错误状态码，如 \"404:0.01,500:0.005,503:0.01\" (仅服务器模式)")
+	flag.StringVar(&config.faultRules, "fault-rules", "", "按 URL 模式指定错误状态码概率，\"模式 状态码:概率,...\" 以 ; 分隔，优先于 -fault (仅服务器模式)")
+	flag.IntVar(&config.faultBodySize, "fault-body-size", 512, "错误响应体大小(字节) (仅服务器模式)")
+	flag.StringVar(&config.faultCacheControl, "fault-cache-control", "", "错误响应的 Cache-Control，为空时不返回 (仅服务器模式)")
+	flag.IntVar(&config.faultRetryAfter, "fault-retry-after", 5, "503 响应的 Retry-After 秒数，0 表示不返回 (仅服务器模式)")
+
+	// 响应体中途故障
+	flag.StringVar(&config.bodyFault, "body-fault", "", "按概率在写出部分响应体后中断: fin 关闭连接、rst 发送 RST、stall 挂起不再发送、noterm chunked 无结束块，如 \"fin:0.01,rst:0.01\" (仅服务器模式)")
+	flag.StringVar(&config.bodyFaultAt, "body-fault-at", "50", "中断前写出的响应体比例，百分比如 50 或区间如 10-90 (仅服务器模式)")
+
+	// 响应体限速
+	flag.StringVar(&config.bodyRate, "body-rate", "", "每个响应的响应体速率(字节/秒)，固定值如 102400 或区间如 51200-204800 (每个响应随机)，为空时不限 (仅服务器模式)")
+	flag.Int64Var(&config.bodyRateGlobal, "body-rate-global", 0, "所有响应共享的响应体总带宽上限(字节/秒)，0 表示不限 (仅服务器模式)")
+	flag.DurationVar(&config.bodyJitter, "body-jitter", 0, "限速发送响应体时每次写出之间额外的随机等待上限，如 20ms (仅服务器模式)")
+
+	// 失败日志与源站访问日志，可用 cache_press analyze 按 trace id 关联
+	flag.DurationVar(&config.readIdleTimeout, "read-idle-timeout", 0, "超过该时间没有收到任何数据时取消请求并计为响应停滞，0 表示不检测 (仅客户端模式)")
+	flag.StringVar(&config.failureLog, "failure-log", "", "客户端失败请求日志文件 (JSON 行)，为空时不记录 (仅客户端模式)")
+	flag.StringVar(&config.accessLog, "access-log", "-", "源站访问日志文件 (JSON 行)，\"-\" 为标准输出，为空时不记录 (仅服务器模式)")
+	flag.IntVar(&config.accessLogBuffer, "access-log-buffer", 65536, "访问日志异步写入队列长度，队列满时丢弃 (仅服务器模式)")
+
 	// 持久连接控制 - 仅服务器使用
 	flag.Float64Var(&config.keepAliveProb, "server-keep-alive-prob", 1.0, "Connection头为keep-alive的概率 (0.0-1.0)")
 	flag.Float64Var(&config.closeConnAfterBodyProb, "server-close-conn-after-body-prob", 0.0, "发完body后主动关闭连接的概率 (0.0-1.0)")
 
+	// 访问日志回放 - 仅回放模式使用
+	flag.StringVar(&config.replayFile, "replay-file", "", "回放的访问日志文件 (仅回放模式)")
+	flag.StringVar(&config.replayFormat, "replay-format", "auto", "访问日志格式: auto/nginx/csv，csv 每行为 timestamp,method,host,uri,size,status")
+	flag.Float64Var(&config.replaySpeed, "replay-speed", 1.0, "回放加速倍数，1 为原始时间间隔，0 表示忽略时间间隔按 -qps 回放")
+
 	// 客户端主动断开连接控制
 	flag.Float64Var(&config.clientSendCloseProb, "client-send-close-prob", 0.0, "发送完请求后主动断开连接的概率 (0.0-1.0)")
 	flag.Float64Var(&config.clientRecvHalfCloseProb, "client-recv-half-close-prob", 0.0, "接收响应body一半时主动断开连接的概率 (0.0-1.0)")
@@ -192,23 +368,10 @@ func parseRespSize(respSizeStr string) []int {
 	return nil
 }
 
-func getRandomResponse(sizeRange []int, ratio float64) []byte {
-	if len(sizeRange) == 1 {
-		// 固定大小
-		return bytes.Repeat([]byte("x"), sizeRange[0])
-	}
-
-	// 范围随机，按比例分配
-	minSize, maxSize := sizeRange[0], sizeRange[1]
-	if rand.Float64() <= ratio {
-		return bytes.Repeat([]byte("x"), minSize)
-	} else {
-		return bytes.Repeat([]byte("x"), maxSize)
-	}
-}
-
+// genURL 生成 id 对应的 URL，对象大小由 id 确定（或来自 -url-state）并编码在路径中
 func genURL(baseURL string, id int64) string {
-	return fmt.Sprintf("%s/path%d.js", baseURL, id)
+	obj := urlState.apply(id, pressObject{size: objectSizeFor(id), sized: true, policy: urlCacheMix.policyFor(id)})
+	return baseURL + objectPath(fmt.Sprintf("path%d", id), obj, ".js")
 }
 
 var id, notHitID int64
@@ -237,32 +400,102 @@ func generateRandomURL(baseURL string, urlCount int, hitRatio float64) string {
 
 	if getID() < int64(urlCount) {
 		// 生成新的随机URL
-		newURL := fmt.Sprintf("%s/path%d.js", baseURL, incrID())
-		return newURL
+		return genURL(baseURL, incrID())
 
 	} else {
-		return fmt.Sprintf("%s/path%d_nocache_%d.js", baseURL, rand.Intn(urlCount*2), incrNotHitID())
+		randID := int64(rand.Intn(urlCount * 2))
+		name := fmt.Sprintf("path%d_nocache_%d", randID, incrNotHitID())
+		return baseURL + objectPath(name, pressObject{size: objectSizeFor(randID), policy: urlCacheMix.policyFor(randID)}, ".js")
 	}
 
 }
 
+// initClient 初始化客户端和回放模式共用的传输层、统计通道和负载模型
+func initClient() {
+	initClientMetrics()
+	initTransport()
+	reqStatCh = make(chan reqStatInfo, 50000)
+	config.respSizeRange = parseRespSize(config.respSizeStr)
+	dist, err := parseSizeDist(config.sizeDistStr, config.respSizeRange, config.diskRatio)
+	if err != nil {
+		log.Fatal("无效的对象大小分布参数: ", err)
+	}
+	respSizeDist = dist
+	fmt.Printf("对象大小分布: %s\n", respSizeDist)
+	urlPopularity, err = parsePopularity(config.popularity, config.urlCount, config.hitRatio)
+	if err != nil {
+		log.Fatal("无效的热度模型参数: ", err)
+	}
+	fmt.Printf("URL 访问热度模型: %s\n", urlPopularity)
+	urlCacheMix, err = parseCacheMix(config.cacheMix)
+	if err != nil {
+		log.Fatal("无效的缓存策略比例参数: ", err)
+	}
+	fmt.Printf("URL 缓存策略比例: %s\n", urlCacheMix)
+
+	initHeaderChecker()
+	initFailureLog()
+	startMetricsServer(nil)
+
+	if config.deferStart > 0 {
+		time.Sleep(time.Duration(config.deferStart) * time.Second)
+	}
+}
+
 func main() {
+	// analyze 子命令使用独立的参数
+	if len(os.Args) > 1 && os.Args[1] == "analyze" {
+		runAnalyze(os.Args[2:])
+		return
+	}
 	flag.Parse()
 
 	switch config.mode {
 	case "server":
+		if config.etag != "strong" && config.etag != "weak" && config.etag != "none" {
+			log.Fatal("无效的 ETag 类型，应为 strong、weak 或 none")
+		}
 		startServer()
-	case "client":
-		initTransport()
-		reqStatCh = make(chan reqStatInfo, 50000)
-		config.respSizeRange = parseRespSize(config.respSizeStr)
-
-		if config.deferStart > 0 {
-			time.Sleep(time.Duration(config.deferStart) * time.Second)
+	case "client", "search":
+		if config.loadModel != loadModelClosed && config.loadModel != loadModelOpen {
+			log.Fatal("无效的负载模型，应为 closed 或 open")
 		}
-
+		if _, err := parseArrival(config.arrival); err != nil {
+			log.Fatal(err)
+		}
+		if err := loadURLState(); err != nil {
+			log.Fatal("加载 URL 状态失败: ", err)
+		}
+		if config.mode == "search" {
+			if config.searchMin <= 0 || config.searchMin > config.searchMax || config.searchPrecision <= 0 || config.searchDwell <= 0 {
+				log.Fatal("无效的搜索参数，需要 0 < -search-min <= -search-max、-search-precision > 0、-search-dwell > 0")
+			}
+			// 搜索过程中出现错误是预期的，按错误率判断 SLO
+			config.ignoreErr = true
+			initClient()
+			runSearch()
+			break
+		}
+		profile, err := loadProfileSpec(config.loadProfileFile, config.loadProfile)
+		if err != nil {
+			log.Fatal("无效的负载曲线: ", err)
+		}
+		runProfile = profile
+		if runProfile != nil {
+			fmt.Printf("负载曲线: %s\n", runProfile)
+		}
+		initClient()
 		runClient()
+	case "replay":
+		if config.replayFile == "" {
+			log.Fatal("回放模式需要指定 -replay-file")
+		}
+		if config.replayFormat != "auto" && config.replayFormat != "nginx" && config.replayFormat != "csv" {
+			log.Fatal("无效的回放日志格式，应为 auto、nginx 或 csv")
+		}
+		initClient()
+		runReplay()
 	default:
-		log.Fatal("无效的模式，应为 server 或 client")
+		log.Fatal("无效的模式，应为 server、client、replay 或 search (关联分析失败请求使用 cache_press analyze 子命令)")
 	}
 }
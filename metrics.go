@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 简单的 Prometheus 文本格式指标实现，只覆盖压测工具需要的计数器、直方图和仪表盘
+
+type promMetric interface {
+	write(w *bufio.Writer)
+}
+
+// promRegistry 指标注册表，按注册顺序输出
+type promRegistry struct {
+	mu      sync.Mutex
+	metrics []promMetric
+}
+
+var metrics = &promRegistry{}
+
+func (r *promRegistry) register(m promMetric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+func (r *promRegistry) writeTo(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	r.mu.Lock()
+	for _, m := range r.metrics {
+		m.write(bw)
+	}
+	r.mu.Unlock()
+	return bw.Flush()
+}
+
+func (r *promRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.writeTo(w)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels 生成 {a="x",b="y"} 形式的标签串，extra 为额外追加的标签（如直方图的 le）
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extra[i], extra[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// promCounterVec 带标签的计数器
+type promCounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*promCounterValue
+}
+
+type promCounterValue struct {
+	labels []string
+	n      int64
+}
+
+func newCounterVec(name, help string, labels ...string) *promCounterVec {
+	c := &promCounterVec{name: name, help: help, labels: labels, values: make(map[string]*promCounterValue)}
+	metrics.register(c)
+	return c
+}
+
+func (c *promCounterVec) add(n int64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	v, ok := c.values[key]
+	if !ok {
+		v = &promCounterValue{labels: labelValues}
+		c.values[key] = v
+	}
+	c.mu.Unlock()
+	atomic.AddInt64(&v.n, n)
+}
+
+func (c *promCounterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+func (c *promCounterVec) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := c.values[k]
+		fmt.Fprintf(w, "%s%s %d\n", c.name, formatLabels(c.labels, v.labels), atomic.LoadInt64(&v.n))
+	}
+	c.mu.Unlock()
+}
+
+// promGaugeFunc 读取时计算取值的仪表盘
+type promGaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func newGaugeFunc(name, help string, fn func() float64) *promGaugeFunc {
+	g := &promGaugeFunc{name: name, help: help, fn: fn}
+	metrics.register(g)
+	return g
+}
+
+func (g *promGaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.fn()))
+}
+
+// latencyBuckets 延迟直方图的桶上界（秒）
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// promHistogramVec 带标签的直方图
+type promHistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*promHistogramValue
+}
+
+type promHistogramValue struct {
+	labels []string
+	counts []int64 // 每个桶的非累计计数，最后一个为 +Inf
+	count  int64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *promHistogramVec {
+	h := &promHistogramVec{name: name, help: help, labels: labels, buckets: buckets,
+		values: make(map[string]*promHistogramValue)}
+	metrics.register(h)
+	return h
+}
+
+func (h *promHistogramVec) observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &promHistogramValue{labels: labelValues, counts: make([]int64, len(h.buckets)+1)}
+		h.values[key] = hv
+	}
+	hv.counts[i]++
+	hv.count++
+	hv.sum += v
+	h.mu.Unlock()
+}
+
+func (h *promHistogramVec) observeDuration(d time.Duration, labelValues ...string) {
+	h.observe(d.Seconds(), labelValues...)
+}
+
+func (h *promHistogramVec) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hv := h.values[k]
+		var cum int64
+		for i, c := range hv.counts {
+			cum += c
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, hv.labels, "le", formatFloat(le)), cum)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, hv.labels), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, hv.labels), hv.count)
+	}
+	h.mu.Unlock()
+}
+
+// startMetricsServer 在 -metrics-addr 上提供 /metrics
+func startMetricsServer(extra map[string]http.Handler) {
+	if config.metricsAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	for pattern, h := range extra {
+		mux.Handle(pattern, h)
+	}
+	fmt.Printf("指标服务监听在 %s/metrics\n", config.metricsAddr)
+	go func() {
+		log.Fatal(http.ListenAndServe(config.metricsAddr, mux))
+	}()
+}
+
+// cacheStatusLabel 将 X-Cache 头归一化为 hit/miss/unknown
+func cacheStatusLabel(xCache string) string {
+	switch {
+	case strings.Contains(xCache, "HIT"):
+		return "hit"
+	case strings.Contains(xCache, "MISS"):
+		return "miss"
+	}
+	return "unknown"
+}
+
+// sizeBucketLabel 将大小归入 2 的幂区间，避免标签基数过高
+func sizeBucketLabel(size int) string {
+	if size <= 0 {
+		return "0"
+	}
+	lo := 1
+	for lo*2 <= size {
+		lo *= 2
+	}
+	return fmt.Sprintf("%d-%d", lo, lo*2-1)
+}
+
+// headerBytes 估算 HTTP 头部的字节数
+func headerBytes(h http.Header) int64 {
+	var n int64
+	for k, vs := range h {
+		for _, v := range vs {
+			n += int64(len(k) + len(v) + 4)
+		}
+	}
+	return n
+}
+
+// countedConn 关闭时减少活跃连接计数
+type countedConn struct {
+	net.Conn
+	active *int64
+	once   sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { atomic.AddInt64(c.active, -1) })
+	return c.Conn.Close()
+}
+
+// clientMetricSet 客户端指标
+type clientMetricSet struct {
+	requests    *promCounterVec
+	corrupt     *promCounterVec
+	firstByte   *promHistogramVec
+	response    *promHistogramVec
+	bytesIn     *promCounterVec
+	bytesOut    *promCounterVec
+	late        *promCounterVec
+	activeConns int64
+	paused      int32 // 非 0 时不记录请求指标，预填充和预热阶段的请求不计入 /metrics
+}
+
+var clientMetrics *clientMetricSet
+
+func initClientMetrics() {
+	m := &clientMetricSet{
+		requests:  newCounterVec("cache_press_client_requests_total", "按 HTTP 状态码（传输错误为 error）和缓存状态统计的请求数", "status", "cache"),
+		corrupt:   newCounterVec("cache_press_client_corrupt_responses_total", "内容校验失败的响应数", "cache"),
+		firstByte: newHistogramVec("cache_press_client_first_byte_seconds", "首包时间（收到响应头）", latencyBuckets, "cache"),
+		response:  newHistogramVec("cache_press_client_response_seconds", "完整响应时间（收完响应体）", latencyBuckets, "cache"),
+		bytesIn:   newCounterVec("cache_press_client_received_bytes_total", "收到的响应体字节数"),
+		bytesOut:  newCounterVec("cache_press_client_sent_bytes_total", "发送的请求字节数（请求行与请求头，估算值）"),
+		late:      newCounterVec("cache_press_client_late_requests_total", "开环模式下实际发送时间晚于计划时间的请求数"),
+	}
+	m.bytesIn.add(0)
+	m.bytesOut.add(0)
+	m.late.add(0)
+	newGaugeFunc("cache_press_client_active_connections", "到目标地址的活跃 TCP 连接数", func() float64 {
+		return float64(atomic.LoadInt64(&m.activeConns))
+	})
+	clientMetrics = m
+}
+
+// pause 暂停或恢复记录请求指标，活跃连接数不受影响
+func (m *clientMetricSet) pause(paused bool) {
+	v := int32(0)
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&m.paused, v)
+}
+
+// recording 返回是否记录请求指标
+func (m *clientMetricSet) recording() bool {
+	return atomic.LoadInt32(&m.paused) == 0
+}
+
+// dialContext 包装拨号函数以统计活跃连接数
+func (m *clientMetricSet) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&m.activeConns, 1)
+		return &countedConn{Conn: conn, active: &m.activeConns}, nil
+	}
+}
+
+// serverMetricSet 源站指标
+type serverMetricSet struct {
+	requests    *promCounterVec
+	bySize      *promCounterVec
+	compressed  *promCounterVec
+	bodyFaults  *promCounterVec
+	response    *promHistogramVec
+	bytesIn     *promCounterVec
+	bytesOut    *promCounterVec
+	activeConns int64
+}
+
+var serverMetrics *serverMetricSet
+
+func initServerMetrics() {
+	m := &serverMetricSet{
+		requests:   newCounterVec("cache_press_server_requests_total", "按 HTTP 状态码和压缩方式统计的请求数", "status", "encoding"),
+		bySize:     newCounterVec("cache_press_server_requests_by_size_total", "按对象大小区间（字节）统计的请求数", "size"),
+		compressed: newCounterVec("cache_press_server_compressed_responses_total", "按压缩方式统计的压缩响应数", "encoding"),
+		bodyFaults: newCounterVec("cache_press_server_body_faults_total", "按类型统计的响应体中途故障注入次数", "kind"),
+		response:   newHistogramVec("cache_press_server_response_seconds", "从收到请求到发完响应体的时间", latencyBuckets),
+		bytesIn:    newCounterVec("cache_press_server_received_bytes_total", "收到的请求字节数（请求头与请求体，估算值）"),
+		bytesOut:   newCounterVec("cache_press_server_sent_bytes_total", "发送的响应体字节数"),
+	}
+	m.bytesIn.add(0)
+	m.bytesOut.add(0)
+	newGaugeFunc("cache_press_server_active_connections", "活跃客户端连接数", func() float64 {
+		return float64(atomic.LoadInt64(&m.activeConns))
+	})
+	serverMetrics = m
+}
+
+// connState 作为 http.Server.ConnState 统计活跃连接数
+func (m *serverMetricSet) connState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		atomic.AddInt64(&m.activeConns, 1)
+	case http.StateHijacked, http.StateClosed:
+		atomic.AddInt64(&m.activeConns, -1)
+	}
+}
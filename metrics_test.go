@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPromExposition(t *testing.T) {
+	c := newCounterVec("test_requests_total", "test counter", "status")
+	c.inc("200")
+	c.add(2, `a"b`)
+	h := newHistogramVec("test_latency_seconds", "test histogram", []float64{0.1, 1})
+	h.observe(0.05)
+	h.observe(0.5)
+	h.observe(5)
+
+	var b strings.Builder
+	if err := metrics.writeTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{status="200"} 1` + "\n",
+		`test_requests_total{status="a\"b"} 2` + "\n",
+		"# TYPE test_latency_seconds histogram\n",
+		`test_latency_seconds_bucket{le="0.1"} 1` + "\n",
+		`test_latency_seconds_bucket{le="1"} 2` + "\n",
+		`test_latency_seconds_bucket{le="+Inf"} 3` + "\n",
+		"test_latency_seconds_sum 5.55\n",
+		"test_latency_seconds_count 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("exposition missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSizeBucketLabel(t *testing.T) {
+	for size, want := range map[int]string{0: "0", 1: "1-1", 1024: "1024-2047", 2047: "1024-2047", 5000: "4096-8191"} {
+		if got := sizeBucketLabel(size); got != want {
+			t.Errorf("sizeBucketLabel(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
+
+func TestClientMetricsPause(t *testing.T) {
+	m := &clientMetricSet{}
+	if !m.recording() {
+		t.Fatal("recording by default")
+	}
+	m.pause(true)
+	if m.recording() {
+		t.Fatal("paused")
+	}
+	m.pause(false)
+	if !m.recording() {
+		t.Fatal("resumed")
+	}
+}
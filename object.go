@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// pressObject 描述一个压测对象的属性。
+// 属性编码在 URL 最后一段路径中，形如 /path123_s1024_v2.js，
+// 这样即使 CDN 去掉了自定义请求头，源站也能从 URL 得到这些属性。
+type pressObject struct {
+	size    int  // 对象大小
+	sized   bool // URL 中编码了大小 (_s)，为 false 时 size 为 0，由请求头或源站默认值决定
+	version int64
+	policy  int // 源站缓存策略规则编号 (_c)，0 表示按模式匹配
+}
+
+// parseObjectPath 从 URL 路径中解析对象属性，未编码的属性保持零值
+func parseObjectPath(p string) pressObject {
+	var obj pressObject
+	base := path.Base(p)
+	if ext := path.Ext(base); ext != "" {
+		base = strings.TrimSuffix(base, ext)
+	}
+	for _, token := range strings.Split(base, "_")[1:] {
+		kind, n, ok := parseObjectToken(token)
+		if !ok {
+			continue
+		}
+		switch kind {
+		case 's':
+			if n >= 0 {
+				obj.size, obj.sized = int(n), true
+			}
+		case 'v':
+			obj.version = n
+		case 'c':
+			obj.policy = int(n)
+		}
+	}
+	return obj
+}
+
+// parseObjectToken 解析 "_" 分隔的一个属性标记，如 s1024、v2、c1
+func parseObjectToken(token string) (byte, int64, bool) {
+	if len(token) < 2 || strings.IndexByte("svc", token[0]) < 0 {
+		return 0, 0, false
+	}
+	n, err := strconv.ParseInt(token[1:], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return token[0], n, true
+}
+
+// objectPath 生成编码了对象属性的 URL 路径，name 为不含扩展名的对象名
+func objectPath(name string, obj pressObject, ext string) string {
+	var b strings.Builder
+	b.WriteString("/")
+	b.WriteString(name)
+	if obj.size > 0 || obj.sized {
+		b.WriteString("_s")
+		b.WriteString(strconv.Itoa(obj.size))
+	}
+	if obj.version > 0 {
+		b.WriteString("_v")
+		b.WriteString(strconv.FormatInt(obj.version, 10))
+	}
+	if obj.policy > 0 {
+		b.WriteString("_c")
+		b.WriteString(strconv.Itoa(obj.policy))
+	}
+	b.WriteString(ext)
+	return b.String()
+}
+
+// idUniform 将 URL id 确定性地映射为 [0,1) 区间的均匀分布值，salt 区分不同用途
+func idUniform(id int64, salt uint64) float64 {
+	return float64(splitmix64(uint64(id)^salt)>>11) / (1 << 53)
+}
+
+// objectSizeFor 按 URL id 从对象大小分布中确定性地采样，同一个 URL 每次请求的大小都相同
+func objectSizeFor(id int64) int {
+	return respSizeDist.size(idUniform(id, 0))
+}
+
+// serverGetObject 解析请求对应的对象属性，URL 中未编码时回退到请求头
+func serverGetObject(r *http.Request) pressObject {
+	obj := parseObjectPath(r.URL.Path)
+	if obj.version == 0 {
+		if v, err := strconv.ParseInt(r.Header.Get("x-press-version"), 10, 64); err == nil {
+			obj.version = v
+		}
+	}
+	return obj
+}
+
+// matchURLPattern 判断 URL 路径是否匹配模式：模式为 "*"（全部）、以 "." 开头的扩展名（如 .js）
+// 或 URL 路径的 glob 模式（如 /live/*，* 不匹配 /）
+func matchURLPattern(pattern, urlPath string) bool {
+	switch {
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "."):
+		return path.Ext(urlPath) == pattern
+	}
+	ok, _ := path.Match(pattern, urlPath)
+	return ok
+}
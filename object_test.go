@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestObjectPathRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		obj  pressObject
+		want string
+	}{
+		{"path1", pressObject{}, "/path1.js"},
+		{"path1", pressObject{size: 1024, sized: true}, "/path1_s1024.js"},
+		{"path1", pressObject{size: 0, sized: true}, "/path1_s0.js"},
+		{"path7_nocache_3", pressObject{size: 10, sized: true, version: 2}, "/path7_nocache_3_s10_v2.js"},
+	}
+	for _, c := range cases {
+		p := objectPath(c.name, c.obj, ".js")
+		if p != c.want {
+			t.Errorf("objectPath = %q, want %q", p, c.want)
+		}
+		if got := parseObjectPath("/dir" + p); got != c.obj {
+			t.Errorf("parseObjectPath(%q) = %+v, want %+v", p, got, c.obj)
+		}
+	}
+}
+
+func TestParseObjectPathNegativeSize(t *testing.T) {
+	if obj := parseObjectPath("/path1_s-5.js"); obj.sized || obj.size != 0 {
+		t.Errorf("negative size should be ignored: %+v", obj)
+	}
+}
+
+func TestObjectSizeForStable(t *testing.T) {
+	respSizeDist = minMaxSize{1024, 1 << 20, 0.7}
+	small := 0
+	for id := int64(0); id < 10000; id++ {
+		size := objectSizeFor(id)
+		if size != objectSizeFor(id) {
+			t.Fatalf("size of id %d is not stable", id)
+		}
+		if size == 1024 {
+			small++
+		}
+	}
+	if small < 6500 || small > 7500 {
+		t.Errorf("small object ratio = %.2f, want about 0.7", float64(small)/10000)
+	}
+}
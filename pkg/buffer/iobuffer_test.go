@@ -507,7 +507,7 @@ func TestPipe_CloseWithError(t *testing.T) {
 		bs := make([]byte, 100)
 		_, err := pipe.Read(bs)
 		if err != io.EOF {
-			t.Fatal(err)
+			t.Error(err)
 		}
 
 	}()
@@ -526,7 +526,7 @@ func TestPipe_ReadAndWrite(t *testing.T) {
 		bs := make([]byte, len(bbs))
 		_, _ = pipe.Read(bs)
 		if !bytes.Equal(bs, bbs) {
-			t.Fatalf("test failed")
+			t.Errorf("test failed")
 		}
 	}()
 	_, _ = pipe.Write(bbs)
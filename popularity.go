@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// urlPicker URL 访问热度模型，决定下一个请求访问哪个 URL
+type urlPicker interface {
+	nextURL(baseURL string) string
+	String() string
+}
+
+// urlPopularity 客户端使用的热度模型
+var urlPopularity urlPicker
+
+// ratioPicker 目标命中率模型：以 hitRatio 的概率随机访问已访问过的 URL，否则访问新 URL
+type ratioPicker struct {
+	urlCount int
+	hitRatio float64
+}
+
+func (p ratioPicker) nextURL(baseURL string) string {
+	return generateRandomURL(baseURL, p.urlCount, p.hitRatio)
+}
+
+func (p ratioPicker) String() string {
+	return fmt.Sprintf("ratio(目标命中率=%.2f, URL数=%d)", p.hitRatio, p.urlCount)
+}
+
+// zipfPicker Zipf 分布，排名 k 的 URL（id=k）被访问的概率正比于 1/k^s。
+// 使用 rejection-inversion 采样 (Hörmann & Derflinger)，支持任意 s > 0，无需预计算 CDF。
+type zipfPicker struct {
+	n        int64
+	exponent float64
+
+	hIntegralX1 float64
+	hIntegralN  float64
+	sCut        float64
+}
+
+func newZipfPicker(n int64, exponent float64) *zipfPicker {
+	z := &zipfPicker{n: n, exponent: exponent}
+	z.hIntegralX1 = z.hIntegral(1.5) - 1
+	z.hIntegralN = z.hIntegral(float64(n) + 0.5)
+	z.sCut = 2 - z.hIntegralInverse(z.hIntegral(2.5)-z.h(2))
+	return z
+}
+
+// sample 返回 [1, n] 之间的排名
+func (z *zipfPicker) sample() int64 {
+	for {
+		u := z.hIntegralN + rand.Float64()*(z.hIntegralX1-z.hIntegralN)
+		x := z.hIntegralInverse(u)
+		k := int64(x + 0.5)
+		if k < 1 {
+			k = 1
+		} else if k > z.n {
+			k = z.n
+		}
+		if float64(k)-x <= z.sCut || u >= z.hIntegral(float64(k)+0.5)-z.h(float64(k)) {
+			return k
+		}
+	}
+}
+
+func (z *zipfPicker) h(x float64) float64 {
+	return math.Exp(-z.exponent * math.Log(x))
+}
+
+func (z *zipfPicker) hIntegral(x float64) float64 {
+	logX := math.Log(x)
+	return zipfHelper2((1-z.exponent)*logX) * logX
+}
+
+func (z *zipfPicker) hIntegralInverse(x float64) float64 {
+	t := x * (1 - z.exponent)
+	if t < -1 {
+		t = -1
+	}
+	return math.Exp(zipfHelper1(t) * x)
+}
+
+// zipfHelper1 计算 log(1+x)/x，x 接近 0 时使用泰勒展开
+func zipfHelper1(x float64) float64 {
+	if math.Abs(x) > 1e-8 {
+		return math.Log1p(x) / x
+	}
+	return 1 - x*(0.5-x*(1.0/3-0.25*x))
+}
+
+// zipfHelper2 计算 (exp(x)-1)/x，x 接近 0 时使用泰勒展开
+func zipfHelper2(x float64) float64 {
+	if math.Abs(x) > 1e-8 {
+		return math.Expm1(x) / x
+	}
+	return 1 + x*0.5*(1+x/3*(1+0.25*x))
+}
+
+func (z *zipfPicker) nextURL(baseURL string) string {
+	return genURL(baseURL, z.sample())
+}
+
+func (z *zipfPicker) String() string {
+	return fmt.Sprintf("zipf(s=%.2f, URL数=%d)", z.exponent, z.n)
+}
+
+// hotColdPicker 热点/冷数据模型：前 hotFrac 比例的 URL 承担 hotProb 比例的请求，集合内均匀访问。
+// interval 大于 0 时热点集合每隔 interval 向后移动 step 个 id，模拟热点随时间迁移。
+type hotColdPicker struct {
+	n        int64
+	hotN     int64
+	hotProb  float64
+	interval time.Duration
+	step     int64
+	start    time.Time
+}
+
+// hotOffset 返回当前热点集合的起始偏移
+func (p *hotColdPicker) hotOffset() int64 {
+	if p.interval <= 0 {
+		return 0
+	}
+	return int64(time.Since(p.start)/p.interval) * p.step % p.n
+}
+
+func (p *hotColdPicker) nextURL(baseURL string) string {
+	var id int64
+	if rand.Float64() < p.hotProb {
+		id = (p.hotOffset()+rand.Int63n(p.hotN))%p.n + 1
+	} else if p.interval > 0 {
+		// 热点移动时冷数据为整个 URL 空间
+		id = rand.Int63n(p.n) + 1
+	} else {
+		id = p.hotN + rand.Int63n(p.n-p.hotN) + 1
+	}
+	return genURL(baseURL, id)
+}
+
+func (p *hotColdPicker) String() string {
+	if p.interval > 0 {
+		return fmt.Sprintf("moving(热点URL数=%d, 热点请求比例=%.2f, 每%v移动%d个, URL数=%d)",
+			p.hotN, p.hotProb, p.interval, p.step, p.n)
+	}
+	return fmt.Sprintf("hotcold(热点URL数=%d, 热点请求比例=%.2f, URL数=%d)", p.hotN, p.hotProb, p.n)
+}
+
+// parsePopularity 解析 -popularity 参数。支持的格式:
+//
+//	ratio                               按 -hit-ratio 目标命中率访问 (默认)
+//	zipf:s                              Zipf 分布，s 为指数
+//	hotcold:热点比例,热点请求比例           如 hotcold:0.1,0.9
+//	moving:热点比例,热点请求比例,间隔[,步长]  热点集合每隔间隔移动步长个 id，步长默认为热点集合的 1/10
+func parsePopularity(spec string, urlCount int, hitRatio float64) (urlPicker, error) {
+	kind, args, _ := strings.Cut(spec, ":")
+	n := int64(urlCount)
+	if kind != "" && kind != "ratio" && n <= 0 {
+		return nil, fmt.Errorf("%s: -url-count 必须大于0", kind)
+	}
+	switch kind {
+	case "", "ratio":
+		return ratioPicker{urlCount: urlCount, hitRatio: hitRatio}, nil
+	case "zipf":
+		s, err := strconv.ParseFloat(args, 64)
+		if err != nil || s <= 0 {
+			return nil, fmt.Errorf("zipf: 格式应为 zipf:s，s 大于0")
+		}
+		return newZipfPicker(n, s), nil
+	case "hotcold", "moving":
+		parts := strings.Split(args, ",")
+		if (kind == "hotcold" && len(parts) != 2) || (kind == "moving" && len(parts) != 3 && len(parts) != 4) {
+			return nil, fmt.Errorf("%s: 参数个数错误", kind)
+		}
+		hotFrac, err1 := strconv.ParseFloat(parts[0], 64)
+		hotProb, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 != nil || err2 != nil || hotFrac <= 0 || hotFrac >= 1 || hotProb < 0 || hotProb > 1 {
+			return nil, fmt.Errorf("%s: 热点比例应在 (0,1) 之间，热点请求比例应在 [0,1] 之间", kind)
+		}
+		p := &hotColdPicker{n: n, hotN: int64(float64(n) * hotFrac), hotProb: hotProb, start: time.Now()}
+		if p.hotN < 1 {
+			p.hotN = 1
+		}
+		if p.hotN >= n {
+			return nil, fmt.Errorf("%s: URL 数量太少，无法划分热点集合", kind)
+		}
+		if kind == "moving" {
+			interval, err := time.ParseDuration(parts[2])
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("moving: 无效的移动间隔 %q", parts[2])
+			}
+			p.interval = interval
+			p.step = p.hotN / 10
+			if len(parts) == 4 {
+				step, err := strconv.ParseInt(parts[3], 10, 64)
+				if err != nil || step <= 0 {
+					return nil, fmt.Errorf("moving: 无效的步长 %q", parts[3])
+				}
+				p.step = step
+			}
+			if p.step < 1 {
+				p.step = 1
+			}
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("未知的热度模型 %q", kind)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestZipfPicker(t *testing.T) {
+	const n = 1000
+	for _, s := range []float64{0.8, 1.0, 1.2} {
+		z := newZipfPicker(n, s)
+		var norm float64
+		for k := 1; k <= n; k++ {
+			norm += math.Pow(float64(k), -s)
+		}
+
+		const samples = 200000
+		counts := make([]int, n+1)
+		for i := 0; i < samples; i++ {
+			k := z.sample()
+			if k < 1 || k > n {
+				t.Fatalf("s=%.1f: sample %d out of range", s, k)
+			}
+			counts[k]++
+		}
+		for _, k := range []int{1, 2, 10} {
+			want := math.Pow(float64(k), -s) / norm
+			got := float64(counts[k]) / samples
+			if math.Abs(got-want) > want*0.05+0.002 {
+				t.Errorf("s=%.1f: P(%d) = %.4f, want %.4f", s, k, got, want)
+			}
+		}
+	}
+}
+
+func TestParsePopularity(t *testing.T) {
+	p, err := parsePopularity("hotcold:0.1,0.9", 1000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc := p.(*hotColdPicker)
+	if hc.hotN != 100 || hc.hotProb != 0.9 || hc.interval != 0 {
+		t.Errorf("unexpected hotcold picker %+v", hc)
+	}
+
+	p, err = parsePopularity("moving:0.1,0.9,10s", 1000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hc := p.(*hotColdPicker); hc.step != 10 {
+		t.Errorf("default moving step = %d, want 10", hc.step)
+	}
+
+	for _, spec := range []string{"zipf:0", "hotcold:1.5,0.9", "moving:0.1,0.9", "lru"} {
+		if _, err := parsePopularity(spec, 1000, 0); err == nil {
+			t.Errorf("parsePopularity(%q) should fail", spec)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 负载曲线：由若干阶段组成，按顺序执行，总时长为各阶段时长之和（替代 -duration）。
+// 每个阶段一行或以 ";" 分隔，"#" 开头的行为注释：
+//
+//	const  QPS 时长                        固定 QPS
+//	ramp   起始QPS 结束QPS 时长              线性爬升/下降
+//	step   起始QPS 结束QPS 步长 每步时长       阶梯递增，时长为 步数 × 每步时长
+//	spike  基础QPS 峰值QPS 周期 峰值时长 时长   方波尖峰，每个周期开始时保持峰值
+//	sine   最低QPS 最高QPS 周期 时长           正弦曲线（压缩的"一天"），从最低点开始
+//
+// 例如 "ramp 0 1000 60s; const 1000 5m; spike 1000 5000 60s 5s 5m; sine 200 2000 10m 30m"
+
+// loadStage 负载曲线的一个阶段
+type loadStage struct {
+	kind     string
+	spec     string
+	a, b     float64 // 起始/结束、基础/峰值、最低/最高 QPS
+	step     float64
+	period   time.Duration // step 的每步时长，spike/sine 的周期
+	width    time.Duration // spike 的峰值时长
+	duration time.Duration
+}
+
+// qpsAt 返回阶段开始 t 之后的目标 QPS
+func (s *loadStage) qpsAt(t time.Duration) float64 {
+	switch s.kind {
+	case "ramp":
+		return s.a + (s.b-s.a)*float64(t)/float64(s.duration)
+	case "step":
+		q := s.a + s.step*math.Floor(float64(t)/float64(s.period))
+		if s.step > 0 {
+			return math.Min(q, s.b)
+		}
+		return math.Max(q, s.b)
+	case "spike":
+		if t%s.period < s.width {
+			return s.b
+		}
+		return s.a
+	case "sine":
+		return s.a + (s.b-s.a)*(1-math.Cos(2*math.Pi*float64(t)/float64(s.period)))/2
+	}
+	return s.a
+}
+
+// loadProfile 负载曲线
+type loadProfile struct {
+	stages []loadStage
+	starts []time.Duration // 每个阶段相对开始时间的偏移
+	total  time.Duration
+}
+
+var runProfile *loadProfile
+
+// runStartTime 压测开始时间，用于确定请求所处的阶段
+var runStartTime time.Time
+
+func parseStageNumbers(fields []string) ([]float64, error) {
+	v := make([]float64, len(fields))
+	for i, f := range fields {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("无效的 QPS %q", f)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func parseStageDurations(fields []string) ([]time.Duration, error) {
+	v := make([]time.Duration, len(fields))
+	for i, f := range fields {
+		d, err := time.ParseDuration(f)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("无效的时长 %q", f)
+		}
+		v[i] = d
+	}
+	return v, nil
+}
+
+// stageArgs 每种阶段的 QPS 参数个数和时长参数个数
+var stageArgs = map[string][2]int{
+	"const": {1, 1},
+	"ramp":  {2, 1},
+	"step":  {3, 1},
+	"spike": {2, 3},
+	"sine":  {2, 2},
+}
+
+func parseLoadStage(spec string) (loadStage, error) {
+	fields := strings.Fields(spec)
+	s := loadStage{kind: fields[0], spec: strings.Join(fields, " ")}
+	n, ok := stageArgs[s.kind]
+	if !ok {
+		return loadStage{}, fmt.Errorf("未知的阶段类型 %q，应为 const、ramp、step、spike 或 sine", s.kind)
+	}
+	if len(fields) != 1+n[0]+n[1] {
+		return loadStage{}, fmt.Errorf("阶段 %q 应有 %d 个 QPS 参数和 %d 个时长参数", spec, n[0], n[1])
+	}
+	qps, err := parseStageNumbers(fields[1 : 1+n[0]])
+	if err != nil {
+		return loadStage{}, fmt.Errorf("阶段 %q: %v", spec, err)
+	}
+	durs, err := parseStageDurations(fields[1+n[0]:])
+	if err != nil {
+		return loadStage{}, fmt.Errorf("阶段 %q: %v", spec, err)
+	}
+
+	switch s.kind {
+	case "const":
+		s.a, s.b, s.duration = qps[0], qps[0], durs[0]
+	case "ramp":
+		s.a, s.b, s.duration = qps[0], qps[1], durs[0]
+	case "step":
+		s.a, s.b, s.step, s.period = qps[0], qps[1], qps[2], durs[0]
+		if s.step == 0 {
+			return loadStage{}, fmt.Errorf("阶段 %q: 步长不能为 0", spec)
+		}
+		if s.b < s.a {
+			s.step = -s.step
+		}
+		steps := math.Ceil((s.b-s.a)/s.step) + 1
+		s.duration = time.Duration(steps) * s.period
+	case "spike":
+		s.a, s.b, s.period, s.width, s.duration = qps[0], qps[1], durs[0], durs[1], durs[2]
+		if s.width > s.period {
+			return loadStage{}, fmt.Errorf("阶段 %q: 峰值时长不能超过周期", spec)
+		}
+	case "sine":
+		s.a, s.b, s.period, s.duration = qps[0], qps[1], durs[0], durs[1]
+	}
+	return s, nil
+}
+
+// parseLoadProfile 解析以 ";" 或换行分隔的阶段，为空时返回 nil
+func parseLoadProfile(spec string) (*loadProfile, error) {
+	p := &loadProfile{}
+	for _, line := range strings.Split(spec, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, part := range strings.Split(line, ";") {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			s, err := parseLoadStage(part)
+			if err != nil {
+				return nil, err
+			}
+			p.starts = append(p.starts, p.total)
+			p.stages = append(p.stages, s)
+			p.total += s.duration
+		}
+	}
+	if len(p.stages) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// loadProfileSpec 合并 -load-profile-file 和 -load-profile，文件中的阶段在前
+func loadProfileSpec(file, spec string) (*loadProfile, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		spec = string(data) + "\n" + spec
+	}
+	return parseLoadProfile(spec)
+}
+
+// stageAt 返回开始 t 之后所处的阶段下标，超过总时长时返回最后一个阶段
+func (p *loadProfile) stageAt(t time.Duration) int {
+	i := 0
+	for i+1 < len(p.starts) && t >= p.starts[i+1] {
+		i++
+	}
+	return i
+}
+
+func (p *loadProfile) qpsAt(t time.Duration) float64 {
+	i := p.stageAt(t)
+	return p.stages[i].qpsAt(t - p.starts[i])
+}
+
+func (p *loadProfile) String() string {
+	parts := make([]string, len(p.stages))
+	for i := range p.stages {
+		parts[i] = p.stages[i].spec
+	}
+	return fmt.Sprintf("%s (共 %v)", strings.Join(parts, "; "), p.total)
+}
+
+// runDuration 压测时长：有负载曲线时为曲线总时长，否则为 -duration
+func runDuration() time.Duration {
+	if runProfile != nil {
+		return runProfile.total
+	}
+	return config.duration
+}
+
+// targetQPS 开始 t 之后的目标 QPS
+func targetQPS(t time.Duration) float64 {
+	if runProfile != nil {
+		return runProfile.qpsAt(t)
+	}
+	return float64(config.qps)
+}
+
+// arrivalStep 按变化的 QPS 推进计划时间时的最大步长
+const arrivalStep = 10 * time.Millisecond
+
+// advanceArrival 从 t 开始按随时间变化的 QPS 累计 work 个请求的量，返回下一个请求的计划时间，
+// 超过 end 时返回 end。QPS 很低（如从 0 开始爬升）时按步长逐步累计，不会按起点的 QPS 等待过久
+func advanceArrival(t, end time.Time, work float64, qpsAt func(time.Time) float64) time.Time {
+	for t.Before(end) {
+		qps := qpsAt(t)
+		if qps > 0 {
+			if dt := time.Duration(work / qps * float64(time.Second)); dt <= arrivalStep {
+				return t.Add(dt)
+			}
+			work -= qps * arrivalStep.Seconds()
+		}
+		t = t.Add(arrivalStep)
+	}
+	return end
+}
+
+// profileQPSAt 返回按开始时间 start 计算的目标 QPS 函数
+func profileQPSAt(start time.Time) func(time.Time) float64 {
+	return func(t time.Time) float64 {
+		return targetQPS(t.Sub(start))
+	}
+}
+
+// profileLimiter 闭环模式下按负载曲线限速，多个连接协程共享。
+// 落后于计划时不追赶积压的请求，与 ratelimit 的行为一致
+type profileLimiter struct {
+	mu    sync.Mutex
+	next  time.Time
+	end   time.Time
+	qpsAt func(time.Time) float64
+}
+
+func newProfileLimiter(start time.Time) *profileLimiter {
+	return &profileLimiter{end: start.Add(runDuration()), qpsAt: profileQPSAt(start)}
+}
+
+// Take 阻塞到下一个请求的计划发送时间并返回该时间
+func (l *profileLimiter) Take() time.Time {
+	l.mu.Lock()
+	if now := time.Now(); l.next.Before(now) {
+		l.next = now
+	}
+	t := l.next
+	l.next = advanceArrival(t, l.end, 1, l.qpsAt)
+	l.mu.Unlock()
+	time.Sleep(time.Until(t))
+	return t
+}
+
+// stageCounters 阶段边界时刻的全局计数快照
+type stageCounters struct {
+	total, success, failed, httpErrors, late, bytes int64
+}
+
+func snapshotCounters() stageCounters {
+	return stageCounters{
+		total:      atomic.LoadInt64(&totalRequests),
+		success:    atomic.LoadInt64(&successRequests),
+		failed:     atomic.LoadInt64(&failedRequests),
+		httpErrors: atomic.LoadInt64(&httpErrorRequests),
+		late:       atomic.LoadInt64(&lateRequests),
+		bytes:      atomic.LoadInt64(&totalBytes),
+	}
+}
+
+// stageStatsSet 按阶段统计：延迟按请求开始时间所在阶段归类，由监控协程写入；
+// 请求数等计数为阶段边界时刻的快照之差，即按请求完成时间归类
+type stageStatsSet struct {
+	latency []latencyStats
+	mu      sync.Mutex
+	marks   []stageCounters // marks[i] 为阶段 i 开始时的计数，最后一个为结束时的计数
+}
+
+var stageStats *stageStatsSet
+
+// startStageStats 在每个阶段开始时记录计数快照
+func startStageStats(p *loadProfile) *stageStatsSet {
+	s := &stageStatsSet{latency: make([]latencyStats, len(p.stages))}
+	s.marks = append(s.marks, snapshotCounters())
+	for i := 1; i < len(p.stages); i++ {
+		time.AfterFunc(p.starts[i], s.mark)
+	}
+	return s
+}
+
+func (s *stageStatsSet) mark() {
+	s.mu.Lock()
+	s.marks = append(s.marks, snapshotCounters())
+	s.mu.Unlock()
+}
+
+func (s *stageStatsSet) record(r reqStatInfo) {
+	s.latency[r.stage].record(r)
+}
+
+// stageReport 一个阶段的统计
+type stageReport struct {
+	Stage         int                       `json:"stage"`
+	Spec          string                    `json:"spec"`
+	StartSec      float64                   `json:"start_sec"`
+	DurationSec   float64                   `json:"duration_sec"`
+	Requests      int64                     `json:"requests"`
+	Success       int64                     `json:"success"`
+	Failed        int64                     `json:"failed"`
+	HTTPErrors    int64                     `json:"http_errors"`
+	Late          int64                     `json:"late,omitempty"`
+	Bytes         int64                     `json:"bytes"`
+	QPS           float64                   `json:"qps"`
+	CacheHitRatio float64                   `json:"cache_hit_ratio"`
+	Latency       map[string]latencySummary `json:"latency"`
+}
+
+// report 在压测结束、监控协程退出后调用
+func (s *stageStatsSet) report(p *loadProfile) []stageReport {
+	s.mark()
+	s.mu.Lock()
+	marks := s.marks
+	s.mu.Unlock()
+
+	reports := make([]stageReport, 0, len(p.stages))
+	for i := range p.stages {
+		if i+1 >= len(marks) {
+			break // 提前结束，之后的阶段没有执行
+		}
+		from, to := marks[i], marks[i+1]
+		r := stageReport{
+			Stage:         i + 1,
+			Spec:          p.stages[i].spec,
+			StartSec:      p.starts[i].Seconds(),
+			DurationSec:   p.stages[i].duration.Seconds(),
+			Requests:      to.total - from.total,
+			Success:       to.success - from.success,
+			Failed:        to.failed - from.failed,
+			HTTPErrors:    to.httpErrors - from.httpErrors,
+			Late:          to.late - from.late,
+			Bytes:         to.bytes - from.bytes,
+			CacheHitRatio: ratio(s.latency[i].hits(), s.latency[i].count()),
+			Latency:       s.latency[i].summarize(),
+		}
+		r.QPS = float64(r.Requests) / r.DurationSec
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+func printStageReports(reports []stageReport) {
+	fmt.Printf("分阶段统计:\n")
+	fmt.Printf("  %-4s %-36s %10s %8s %8s %8s %8s %10s %12s %12s\n",
+		"阶段", "曲线", "请求数", "QPS", "失败", "HTTP错误", "命中率", "首包p99", "响应p99", "未按时发送")
+	for _, r := range reports {
+		fmt.Printf("  %-4d %-36s %10d %8.1f %8d %8d %7.2f%% %8.1fms %10.1fms %12d\n",
+			r.Stage, r.Spec, r.Requests, r.QPS, r.Failed, r.HTTPErrors, r.CacheHitRatio*100,
+			r.Latency["first_byte_all"].P99Ms, r.Latency["total_all"].P99Ms, r.Late)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseLoadProfile(t *testing.T) {
+	p, err := parseLoadProfile("# 预热\nramp 0 100 10s; const 100 5s\nstep 100 400 100 2s\nspike 10 50 10s 2s 20s; sine 10 30 4s 8s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.stages) != 5 {
+		t.Fatalf("stages = %d", len(p.stages))
+	}
+	// step 100..400 步长 100 共 4 步
+	if p.stages[2].duration != 8*time.Second {
+		t.Fatalf("step duration %v", p.stages[2].duration)
+	}
+	if p.total != 10*time.Second+5*time.Second+8*time.Second+20*time.Second+8*time.Second {
+		t.Fatalf("total %v", p.total)
+	}
+
+	cases := []struct {
+		t   time.Duration
+		qps float64
+	}{
+		{0, 0},
+		{5 * time.Second, 50},
+		{12 * time.Second, 100},
+		{15 * time.Second, 100},
+		{17 * time.Second, 200},
+		{22 * time.Second, 400},
+		{24 * time.Second, 50},
+		{27 * time.Second, 10},
+		{34 * time.Second, 50},
+		{43 * time.Second, 10},
+		{45 * time.Second, 30},
+	}
+	for _, c := range cases {
+		if got := p.qpsAt(c.t); math.Abs(got-c.qps) > 1e-6 {
+			t.Errorf("qpsAt(%v) = %v, want %v", c.t, got, c.qps)
+		}
+	}
+	if i := p.stageAt(15 * time.Second); i != 2 {
+		t.Errorf("stageAt(15s) = %d", i)
+	}
+	if i := p.stageAt(time.Hour); i != 4 {
+		t.Errorf("stageAt past end = %d", i)
+	}
+
+	for _, bad := range []string{"ramp 0 100", "flat 10 1s", "const -1 1s", "spike 1 2 1s 2s 5s", "step 1 10 0 1s", "const 10 abc"} {
+		if _, err := parseLoadProfile(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+	if p, err := parseLoadProfile(" ; "); p != nil || err != nil {
+		t.Errorf("empty profile: %v %v", p, err)
+	}
+}
+
+func TestAdvanceArrivalRamp(t *testing.T) {
+	// 从 0 线性爬升到 100 QPS，10 秒内应调度约 500 个请求
+	start := time.Now()
+	end := start.Add(10 * time.Second)
+	qps := func(t time.Time) float64 { return 100 * t.Sub(start).Seconds() / 10 }
+	n := 0
+	for at := advanceArrival(start, end, 1, qps); at.Before(end); at = advanceArrival(at, end, 1, qps) {
+		n++
+	}
+	if n < 490 || n > 510 {
+		t.Fatalf("scheduled %d requests, want ~500", n)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// httpRange 表示一个已解析的字节区间 [start, start+length)
+type httpRange struct {
+	start, length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+func (r httpRange) mimeHeader(contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Range": {r.contentRange(size)},
+		"Content-Type":  {contentType},
+	}
+}
+
+var (
+	// errInvalidRange Range 头语法错误，按 RFC 7233 应忽略该头
+	errInvalidRange = errors.New("invalid range")
+	// errNoOverlap 所有区间都不在对象范围内，应返回 416
+	errNoOverlap = errors.New("invalid range: failed to overlap")
+)
+
+// parseRange 解析 Range 头，支持 "bytes=a-b"、"bytes=a-"、"bytes=-n" 以及逗号分隔的多区间
+func parseRange(s string, size int64) ([]httpRange, error) {
+	if s == "" {
+		return nil, nil
+	}
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return nil, errInvalidRange
+	}
+	var ranges []httpRange
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(b):], ",") {
+		ra = textproto.TrimString(ra)
+		if ra == "" {
+			continue
+		}
+		startStr, endStr, ok := strings.Cut(ra, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		startStr, endStr = textproto.TrimString(startStr), textproto.TrimString(endStr)
+		var r httpRange
+		if startStr == "" {
+			// 后缀区间 bytes=-n，表示最后 n 个字节
+			if endStr == "" || endStr[0] == '-' {
+				return nil, errInvalidRange
+			}
+			i, err := strconv.ParseInt(endStr, 10, 64)
+			if i < 0 || err != nil {
+				return nil, errInvalidRange
+			}
+			if i == 0 {
+				noOverlap = true
+				continue
+			}
+			if i > size {
+				i = size
+			}
+			r.start = size - i
+			r.length = size - r.start
+		} else {
+			i, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || i < 0 {
+				return nil, errInvalidRange
+			}
+			if i >= size {
+				// 起始位置超出对象大小
+				noOverlap = true
+				continue
+			}
+			r.start = i
+			if endStr == "" {
+				// 开放区间 bytes=a-
+				r.length = size - r.start
+			} else {
+				i, err := strconv.ParseInt(endStr, 10, 64)
+				if err != nil || r.start > i {
+					return nil, errInvalidRange
+				}
+				if i >= size {
+					i = size - 1
+				}
+				r.length = i - r.start + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if noOverlap && len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+func sumRangesSize(ranges []httpRange) (size int64) {
+	for _, ra := range ranges {
+		size += ra.length
+	}
+	return
+}
+
+// writeMultipartRanges 按 multipart/byteranges 格式写出多个区间，返回 Content-Type
+func writeMultipartRanges(buf *bytes.Buffer, body []byte, ranges []httpRange, contentType string) string {
+	mw := multipart.NewWriter(buf)
+	size := int64(len(body))
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(ra.mimeHeader(contentType, size))
+		if err != nil {
+			break
+		}
+		_, _ = part.Write(body[ra.start : ra.start+ra.length])
+	}
+	_ = mw.Close()
+	return "multipart/byteranges; boundary=" + mw.Boundary()
+}
+
+// checkIfRange 判断 If-Range 条件是否满足；不满足时应忽略 Range 返回完整内容
+func checkIfRange(ifRange, etag, lastModified string) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		// If-Range 要求强比较，弱 ETag 永不匹配
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	return lastModified != "" && ifRange == lastModified
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		header string
+		size   int64
+		want   []httpRange
+		err    error
+	}{
+		{"bytes=0-499", 1000, []httpRange{{0, 500}}, nil},
+		{"bytes=500-", 1000, []httpRange{{500, 500}}, nil},
+		{"bytes=-300", 1000, []httpRange{{700, 300}}, nil},
+		{"bytes=-5000", 1000, []httpRange{{0, 1000}}, nil},
+		{"bytes=900-1999", 1000, []httpRange{{900, 100}}, nil},
+		{"bytes=0-0, 10-19", 1000, []httpRange{{0, 1}, {10, 10}}, nil},
+		{"bytes=1000-", 1000, nil, errNoOverlap},
+		{"bytes=-0", 1000, nil, errNoOverlap},
+		{"bytes=2000-, 0-9", 1000, []httpRange{{0, 10}}, nil},
+		{"bytes=5-1", 1000, nil, errInvalidRange},
+		{"items=0-1", 1000, nil, errInvalidRange},
+		{"bytes=abc", 1000, nil, errInvalidRange},
+	}
+	for _, c := range cases {
+		got, err := parseRange(c.header, c.size)
+		if err != c.err {
+			t.Errorf("parseRange(%q) err = %v, want %v", c.header, err, c.err)
+			continue
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("parseRange(%q) = %v, want %v", c.header, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("parseRange(%q) = %v, want %v", c.header, got, c.want)
+			}
+		}
+	}
+}
+
+func TestWriteMultipartRanges(t *testing.T) {
+	body := []byte("0123456789abcdefghij")
+	ranges := []httpRange{{0, 3}, {15, 5}}
+
+	var buf bytes.Buffer
+	ct := writeMultipartRanges(&buf, body, ranges, "application/octet-stream")
+	_, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(&buf, params["boundary"])
+	for _, ra := range ranges {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := part.Header.Get("Content-Range"), ra.contentRange(int64(len(body))); got != want {
+			t.Errorf("Content-Range = %q, want %q", got, want)
+		}
+		data, _ := io.ReadAll(part)
+		if !bytes.Equal(data, body[ra.start:ra.start+ra.length]) {
+			t.Errorf("part body = %q, want %q", data, body[ra.start:ra.start+ra.length])
+		}
+	}
+}
+
+func TestCheckIfRange(t *testing.T) {
+	const etag = `"abc"`
+	const lm = "Mon, 02 Jan 2006 15:04:05 GMT"
+	cases := []struct {
+		ifRange string
+		etag    string
+		want    bool
+	}{
+		{"", "", true},
+		{etag, etag, true},
+		{`"other"`, etag, false},
+		{`W/"abc"`, `W/"abc"`, false},
+		{lm, etag, true},
+		{"Tue, 03 Jan 2006 15:04:05 GMT", etag, false},
+		{etag, "", false},
+	}
+	for _, c := range cases {
+		if got := checkIfRange(c.ifRange, c.etag, lm); got != c.want {
+			t.Errorf("checkIfRange(%q, %q) = %v, want %v", c.ifRange, c.etag, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/ratelimit"
+)
+
+// replayEntry 访问日志中的一条记录
+type replayEntry struct {
+	ts     time.Time
+	method string
+	host   string
+	uri    string
+	size   int64
+}
+
+// nginxCombinedRe 匹配 nginx combined 格式:
+// $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"
+var nginxCombinedRe = regexp.MustCompile(`^\S+ \S+ \S+ \[([^\]]+)\] "(\S+) (\S+)[^"]*" \d{3} (\d+|-)`)
+
+func parseNginxLine(line string) (replayEntry, bool) {
+	m := nginxCombinedRe.FindStringSubmatch(line)
+	if m == nil {
+		return replayEntry{}, false
+	}
+	ts, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[1])
+	if err != nil {
+		return replayEntry{}, false
+	}
+	size, _ := strconv.ParseInt(m[4], 10, 64)
+	return replayEntry{ts: ts, method: m[2], uri: m[3], size: size}, true
+}
+
+// parseCSVLine 解析 timestamp,method,host,uri,size,status 格式的一行，
+// timestamp 可以是 Unix 秒（可带小数）或 RFC3339 时间
+func parseCSVLine(line string) (replayEntry, bool) {
+	fields, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil || len(fields) < 5 {
+		return replayEntry{}, false
+	}
+	var ts time.Time
+	if sec, err := strconv.ParseFloat(fields[0], 64); err == nil {
+		ts = time.Unix(0, int64(sec*float64(time.Second)))
+	} else if ts, err = time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+		return replayEntry{}, false
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(fields[4]), 10, 64)
+	if err != nil {
+		return replayEntry{}, false
+	}
+	return replayEntry{
+		ts:     ts,
+		method: strings.ToUpper(strings.TrimSpace(fields[1])),
+		host:   strings.TrimSpace(fields[2]),
+		uri:    strings.TrimSpace(fields[3]),
+		size:   size,
+	}, true
+}
+
+// escapeObjectTokens 将对象名中会被解析为属性标记的片段（如 "_v2"、"_s100"）的 "_" 改为 "-"，
+// 避免原始路径被当作版本号、缓存策略或大小
+func escapeObjectTokens(name string) string {
+	parts := strings.Split(name, "_")
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for _, token := range parts[1:] {
+		if _, _, ok := parseObjectToken(token); ok {
+			b.WriteByte('-')
+		} else {
+			b.WriteByte('_')
+		}
+		b.WriteString(token)
+	}
+	return b.String()
+}
+
+// replayURI 将日志中的 URI 改写为 cache_press 源站的对象路径：
+// 原始 host 作为路径前缀，原始大小（包括 0）编码到最后一段路径中，查询串保持不变
+func replayURI(e replayEntry) string {
+	p, query, hasQuery := strings.Cut(e.uri, "?")
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	if e.host != "" {
+		p = "/" + e.host + p
+	}
+	i := strings.LastIndex(p, "/")
+	dir, base := p[:i], p[i+1:]
+	ext := path.Ext(base)
+	name := escapeObjectTokens(strings.TrimSuffix(base, ext))
+	p = dir + objectPath(name, pressObject{size: int(max(e.size, 0)), sized: true}, ext)
+	if hasQuery {
+		p += "?" + query
+	}
+	return p
+}
+
+// flagPassed 判断命令行是否显式指定了该参数
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+// runReplay 按访问日志回放请求，默认回放整个文件，显式指定 -duration 时到时停止
+func runReplay() {
+	baseURL := getBaseURL()
+
+	f, err := os.Open(config.replayFile)
+	if err != nil {
+		log.Fatal("打开回放文件失败: ", err)
+	}
+	defer f.Close()
+
+	format := config.replayFormat
+	var limiter ratelimit.Limiter
+	if config.replaySpeed <= 0 {
+		limiter = ratelimit.New(config.qps)
+		fmt.Printf("回放文件: %s, 忽略原始时间间隔, QPS: %d\n", config.replayFile, config.qps)
+	} else {
+		fmt.Printf("回放文件: %s, 按原始时间间隔回放, 加速倍数: %.2f\n", config.replayFile, config.replaySpeed)
+	}
+
+	// 无缓冲通道：所有连接都忙时回放会等待，不会堆积请求
+	jobs := make(chan replayEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < config.conns; i++ {
+		wg.Add(1)
+		go func(connID int) {
+			defer wg.Done()
+
+			client := &http.Client{
+				Timeout:   30 * time.Second,
+				Transport: transport,
+			}
+			for e := range jobs {
+				doRequest(client, connID, e.method, baseURL+replayURI(e), time.Time{})
+			}
+		}(i)
+	}
+
+	startTime := time.Now()
+	clientStat()
+
+	limitDuration := flagPassed("duration")
+	var replayed, skipped, remaining int64
+	var firstTs time.Time
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if limitDuration && time.Since(startTime) >= config.duration {
+			// 统计未回放的行数
+			for remaining = 1; scanner.Scan(); remaining++ {
+			}
+			break
+		}
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if format == "auto" {
+			if _, ok := parseNginxLine(line); ok {
+				format = "nginx"
+			} else if _, ok := parseCSVLine(line); ok {
+				format = "csv"
+			} else {
+				// 可能是表头，继续探测下一行
+				skipped++
+				continue
+			}
+		}
+
+		var e replayEntry
+		var ok bool
+		if format == "nginx" {
+			e, ok = parseNginxLine(line)
+		} else {
+			e, ok = parseCSVLine(line)
+		}
+		// 只回放没有请求体的方法
+		if !ok || (e.method != http.MethodGet && e.method != http.MethodHead) {
+			skipped++
+			continue
+		}
+
+		if limiter != nil {
+			limiter.Take()
+		} else {
+			if firstTs.IsZero() {
+				firstTs = e.ts
+			}
+			due := startTime.Add(time.Duration(float64(e.ts.Sub(firstTs)) / config.replaySpeed))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		jobs <- e
+		replayed++
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Println("读取回放文件出错:", err)
+	}
+	close(jobs)
+	wg.Wait()
+
+	// 停止监控
+	done <- true
+
+	fmt.Printf("\n回放记录数: %d, 跳过记录数: %d, 日志格式: %s\n", replayed, skipped, format)
+	if remaining > 0 {
+		fmt.Printf("已达到 -duration=%v，回放提前结束，剩余 %d 行未回放\n", config.duration, remaining)
+	}
+	printFinalStats(baseURL, startTime)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNginxLine(t *testing.T) {
+	line := `10.0.0.1 - - [16/Oct/2026:10:00:01 +0800] "GET /static/app.min.js?v=3 HTTP/1.1" 200 52341 "-" "curl/8.0"`
+	e, ok := parseNginxLine(line)
+	if !ok {
+		t.Fatal("failed to parse nginx line")
+	}
+	if e.method != "GET" || e.uri != "/static/app.min.js?v=3" || e.size != 52341 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e.ts.Unix() != time.Date(2026, 10, 16, 2, 0, 1, 0, time.UTC).Unix() {
+		t.Errorf("unexpected timestamp %v", e.ts)
+	}
+	if _, ok := parseNginxLine("timestamp,method,host,uri,size,status"); ok {
+		t.Error("csv header parsed as nginx line")
+	}
+}
+
+func TestParseCSVLine(t *testing.T) {
+	e, ok := parseCSVLine(`1792137601.5,get,img.test.com,/a/b.jpg,1024,200`)
+	if !ok {
+		t.Fatal("failed to parse csv line")
+	}
+	if e.method != "GET" || e.host != "img.test.com" || e.uri != "/a/b.jpg" || e.size != 1024 ||
+		e.ts.UnixMilli() != 1792137601500 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if _, ok := parseCSVLine("timestamp,method,host,uri,size,status"); ok {
+		t.Error("csv header should not parse")
+	}
+}
+
+func TestReplayURI(t *testing.T) {
+	cases := []struct {
+		e    replayEntry
+		want string
+	}{
+		{replayEntry{uri: "/static/app.min.js?v=3", size: 100}, "/static/app.min_s100.js?v=3"},
+		{replayEntry{host: "img.test.com", uri: "/a/b", size: 7}, "/img.test.com/a/b_s7"},
+		{replayEntry{uri: "/", size: 5}, "/_s5"},
+		// 大小为 0 (nginx 中的 0 或 -) 也要编码，否则源站返回默认大小
+		{replayEntry{uri: "/empty.gif", size: 0}, "/empty_s0.gif"},
+		// 原始路径中像属性标记的片段不能被解析为版本号、缓存策略或大小
+		{replayEntry{uri: "/lib/jquery_v3_c1.js", size: 90}, "/lib/jquery-v3-c1_s90.js"},
+		{replayEntry{uri: "/img/photo_s100_large.jpg", size: 2048}, "/img/photo-s100_large_s2048.jpg"},
+	}
+	for _, c := range cases {
+		got := replayURI(c.e)
+		if got != c.want {
+			t.Errorf("replayURI(%+v) = %q, want %q", c.e, got, c.want)
+		}
+		want := pressObject{size: int(c.e.size), sized: true}
+		if obj := parseObjectPath(got); obj != want {
+			t.Errorf("parseObjectPath(%q) = %+v, want %+v", got, obj, want)
+		}
+	}
+
+	// nginx 日志中大小为 "-" 时按 0 回放
+	e, ok := parseNginxLine(`10.0.0.1 - - [16/Oct/2026:10:00:01 +0800] "HEAD /a_v2.js HTTP/1.1" 304 - "-" "curl/8.0"`)
+	if !ok || replayURI(e) != "/a-v2_s0.js" {
+		t.Errorf("nginx '-' size: %v %q", ok, replayURI(e))
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// latencySummary 延迟直方图的摘要，单位毫秒
+type latencySummary struct {
+	Count  int64   `json:"count"`
+	P50Ms  float64 `json:"p50_ms"`
+	P90Ms  float64 `json:"p90_ms"`
+	P99Ms  float64 `json:"p99_ms"`
+	P999Ms float64 `json:"p999_ms"`
+	MeanMs float64 `json:"mean_ms"`
+	MinMs  float64 `json:"min_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func summarizeLatency(h *latencyHist) latencySummary {
+	return latencySummary{
+		Count:  h.total,
+		P50Ms:  durationMs(h.quantile(0.5)),
+		P90Ms:  durationMs(h.quantile(0.9)),
+		P99Ms:  durationMs(h.quantile(0.99)),
+		P999Ms: durationMs(h.quantile(0.999)),
+		MeanMs: durationMs(h.mean()),
+		MinMs:  durationMs(time.Duration(h.min) * time.Microsecond),
+		MaxMs:  durationMs(time.Duration(h.max) * time.Microsecond),
+	}
+}
+
+// summarize 返回按 首包/响应 × 全部/命中/未命中 划分的延迟摘要
+func (s *latencyStats) summarize() map[string]latencySummary {
+	firstByte, total := s.all()
+	return map[string]latencySummary{
+		"first_byte_all":  summarizeLatency(firstByte),
+		"first_byte_hit":  summarizeLatency(&s.firstByte[1]),
+		"first_byte_miss": summarizeLatency(&s.firstByte[0]),
+		"total_all":       summarizeLatency(total),
+		"total_hit":       summarizeLatency(&s.total[1]),
+		"total_miss":      summarizeLatency(&s.total[0]),
+	}
+}
+
+type sizeBucketReport struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+	Count int64 `json:"count"`
+}
+
+func (h *sizeHistogram) report() []sizeBucketReport {
+	var buckets []sizeBucketReport
+	for i := range h.buckets {
+		n := atomic.LoadInt64(&h.buckets[i])
+		if n == 0 {
+			continue
+		}
+		b := sizeBucketReport{Count: n}
+		if i > 0 {
+			b.Min, b.Max = int64(1)<<(i-1), int64(1)<<i-1
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// runReport -report-json 输出的运行报告
+type runReport struct {
+	Mode             string                    `json:"mode"`
+	Target           string                    `json:"target"`
+	StartTime        time.Time                 `json:"start_time"`
+	EndTime          time.Time                 `json:"end_time"`
+	DurationSec      float64                   `json:"duration_sec"`
+	Config           map[string]string         `json:"config"`
+	SizeDistribution string                    `json:"size_distribution"`
+	Popularity       string                    `json:"popularity"`
+	LoadModel        string                    `json:"load_model"`
+	LoadProfile      string                    `json:"load_profile,omitempty"`
+	LateRequests     int64                     `json:"late_requests,omitempty"`
+	SendLag          *latencySummary           `json:"send_lag,omitempty"`
+	Requests         int64                     `json:"requests"`
+	Success          int64                     `json:"success"`
+	Failed           int64                     `json:"failed"`
+	Corrupt          int64                     `json:"corrupt"`
+	Bytes            int64                     `json:"bytes"`
+	QPS              float64                   `json:"qps"`
+	SuccessRatio     float64                   `json:"success_ratio"`
+	CacheHits        int64                     `json:"cache_hits"`
+	CacheHitRatio    float64                   `json:"cache_hit_ratio"`
+	Errors           map[string]int64          `json:"errors"`
+	Statuses         map[string]int64          `json:"statuses"`
+	Latency          map[string]latencySummary `json:"latency"`
+	SizeHistogram    []sizeBucketReport        `json:"size_histogram"`
+	HeaderMismatches map[string]int64          `json:"header_mismatches,omitempty"`
+	Phases           []phaseReport             `json:"phases,omitempty"` // 预填充和预热阶段，不计入其他统计
+	Stages           []stageReport             `json:"stages,omitempty"`
+	Search           *searchReport             `json:"search,omitempty"`
+}
+
+// configSnapshot 返回所有命令行参数的当前值
+func configSnapshot() map[string]string {
+	m := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		m[f.Name] = f.Value.String()
+	})
+	return m
+}
+
+// errorBreakdown 返回按类别划分的错误计数
+func errorBreakdown() map[string]int64 {
+	return map[string]int64{
+		"transport":       atomic.LoadInt64(&failedRequests),
+		"http_status":     atomic.LoadInt64(&httpErrorRequests),
+		"corrupt":         atomic.LoadInt64(&corruptRequests),
+		"header_mismatch": atomic.LoadInt64(&hdrMismatchRequests),
+		"short_body":      atomic.LoadInt64(&shortBodyRequests),
+		"long_body":       atomic.LoadInt64(&longBodyRequests),
+		"stall":           atomic.LoadInt64(&stalledRequests),
+		"slice_md5":       atomic.LoadInt64(&sliceMismatchRequests),
+	}
+}
+
+func ratio(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// writeReportJSON 将最终统计写入 -report-json 指定的文件
+func writeReportJSON(baseURL string, startTime, endTime time.Time, stages []stageReport) error {
+	total := atomic.LoadInt64(&totalRequests)
+	success := atomic.LoadInt64(&successRequests)
+	elapsed := endTime.Sub(startTime).Seconds()
+	report := runReport{
+		Mode:             config.mode,
+		Target:           baseURL,
+		StartTime:        startTime,
+		EndTime:          endTime,
+		DurationSec:      elapsed,
+		Config:           configSnapshot(),
+		SizeDistribution: fmt.Sprint(respSizeDist),
+		Popularity:       fmt.Sprint(urlPopularity),
+		LoadModel:        config.loadModel,
+		Requests:         total,
+		Success:          success,
+		Failed:           atomic.LoadInt64(&failedRequests),
+		Corrupt:          atomic.LoadInt64(&corruptRequests),
+		Bytes:            atomic.LoadInt64(&totalBytes),
+		QPS:              float64(total) / elapsed,
+		SuccessRatio:     ratio(success, total),
+		CacheHits:        runLatency.hits(),
+		CacheHitRatio:    ratio(runLatency.hits(), runLatency.count()),
+		Errors:           errorBreakdown(),
+		Statuses:         statusBreakdown(),
+		Latency:          runLatency.summarize(),
+		SizeHistogram:    observedSizes.report(),
+		Phases:           warmupPhases,
+		Stages:           stages,
+		Search:           searchResult,
+	}
+	if runProfile != nil {
+		report.LoadProfile = runProfile.String()
+	}
+	if config.loadModel == loadModelOpen {
+		lag := summarizeLatency(&sendLag)
+		report.LateRequests = atomic.LoadInt64(&lateRequests)
+		report.SendLag = &lag
+	}
+	if headerChecker != nil {
+		report.HeaderMismatches = headerChecker.snapshot()
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.reportJSON, append(data, '\n'), 0644)
+}
+
+// timeSeriesWriter 按统计周期向 -timeseries-csv 文件写入一行数据
+type timeSeriesWriter struct {
+	f         *os.File
+	w         *csv.Writer
+	start     time.Time
+	last      time.Time
+	lastTotal int64
+}
+
+var timeSeriesHeader = []string{
+	"time", "elapsed_sec", "round", "requests", "total_requests", "success", "failed", "corrupt", "bytes",
+	"qps", "cache_hit_ratio",
+	"first_byte_p50_ms", "first_byte_p90_ms", "first_byte_p99_ms", "first_byte_p999_ms",
+	"total_p50_ms", "total_p90_ms", "total_p99_ms", "total_p999_ms",
+}
+
+func newTimeSeriesWriter(file string, start time.Time) (*timeSeriesWriter, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	t := &timeSeriesWriter{f: f, w: csv.NewWriter(f), start: start, last: start}
+	if err := t.w.Write(timeSeriesHeader); err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.w.Flush()
+	return t, t.w.Error()
+}
+
+func formatMs(d time.Duration) string {
+	return strconv.FormatFloat(durationMs(d), 'f', 3, 64)
+}
+
+// write 写入一个统计周期的数据，interval 为该周期的延迟统计
+func (t *timeSeriesWriter) write(round int64, interval *latencyStats) {
+	now := time.Now()
+	total := atomic.LoadInt64(&totalRequests)
+	qps := 0.0
+	if sec := now.Sub(t.last).Seconds(); sec > 0 {
+		qps = float64(total-t.lastTotal) / sec
+	}
+	firstByte, resp := interval.all()
+	_ = t.w.Write([]string{
+		now.Format(time.RFC3339Nano),
+		strconv.FormatFloat(now.Sub(t.start).Seconds(), 'f', 3, 64),
+		strconv.FormatInt(round, 10),
+		strconv.FormatInt(total-t.lastTotal, 10),
+		strconv.FormatInt(total, 10),
+		strconv.FormatInt(atomic.LoadInt64(&successRequests), 10),
+		strconv.FormatInt(atomic.LoadInt64(&failedRequests), 10),
+		strconv.FormatInt(atomic.LoadInt64(&corruptRequests), 10),
+		strconv.FormatInt(atomic.LoadInt64(&totalBytes), 10),
+		strconv.FormatFloat(qps, 'f', 2, 64),
+		strconv.FormatFloat(ratio(interval.hits(), interval.count()), 'f', 4, 64),
+		formatMs(firstByte.quantile(0.5)), formatMs(firstByte.quantile(0.9)),
+		formatMs(firstByte.quantile(0.99)), formatMs(firstByte.quantile(0.999)),
+		formatMs(resp.quantile(0.5)), formatMs(resp.quantile(0.9)),
+		formatMs(resp.quantile(0.99)), formatMs(resp.quantile(0.999)),
+	})
+	t.w.Flush()
+	t.last = now
+	t.lastTotal = total
+}
+
+func (t *timeSeriesWriter) close() {
+	t.w.Flush()
+	t.f.Close()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setReportStats 设置一次运行的统计：4 个请求，其中 1 个失败，3 个响应中 2 个命中
+func setReportStats(t *testing.T) {
+	t.Cleanup(func() {
+		resetClientStats()
+		runLatency = latencyStats{}
+	})
+	atomic.StoreInt64(&totalRequests, 4)
+	atomic.StoreInt64(&successRequests, 3)
+	atomic.StoreInt64(&failedRequests, 1)
+	atomic.StoreInt64(&totalBytes, 3072)
+	for _, hit := range []bool{true, true, false} {
+		runLatency.record(reqStatInfo{respTime: 20 * time.Millisecond, firstByteTime: 5 * time.Millisecond, cacheHit: hit})
+	}
+}
+
+func TestWriteReportJSON(t *testing.T) {
+	setReportStats(t)
+	oldFile := config.reportJSON
+	t.Cleanup(func() { config.reportJSON = oldFile })
+	config.reportJSON = filepath.Join(t.TempDir(), "report.json")
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := writeReportJSON("http://127.0.0.1:8080", start, start.Add(2*time.Second), nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(config.reportJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r runReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Target != "http://127.0.0.1:8080" || r.DurationSec != 2 || r.Requests != 4 || r.Success != 3 ||
+		r.Failed != 1 || r.Bytes != 3072 || r.QPS != 2 || r.SuccessRatio != 0.75 {
+		t.Fatalf("totals: %+v", r)
+	}
+	// 命中率的分母为收到响应的请求数，不含失败的请求
+	if r.CacheHits != 2 || r.CacheHitRatio != 2.0/3 {
+		t.Fatalf("cache hits %d ratio %v", r.CacheHits, r.CacheHitRatio)
+	}
+	if r.Latency["total_all"].Count != 3 || r.Latency["first_byte_hit"].Count != 2 || r.Errors["transport"] != 1 {
+		t.Fatalf("latency %v errors %v", r.Latency, r.Errors)
+	}
+	if _, ok := r.Config["duration"]; !ok {
+		t.Fatal("config snapshot missing flags")
+	}
+}
+
+func TestTimeSeriesWriter(t *testing.T) {
+	setReportStats(t)
+	file := filepath.Join(t.TempDir(), "ts.csv")
+	w, err := newTimeSeriesWriter(file, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.write(1, &runLatency)
+	w.close()
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || len(rows[0]) != len(timeSeriesHeader) || len(rows[1]) != len(timeSeriesHeader) {
+		t.Fatalf("rows: %v", rows)
+	}
+	row := map[string]string{}
+	for i, name := range rows[0] {
+		row[name] = rows[1][i]
+	}
+	if row["round"] != "1" || row["requests"] != "4" || row["total_requests"] != "4" || row["failed"] != "1" ||
+		row["cache_hit_ratio"] != "0.6667" {
+		t.Fatalf("row: %v", row)
+	}
+}
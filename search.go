@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/ratelimit"
+)
+
+// 饱和点搜索 (-mode=search)：依次在不同 QPS 级别各压测 -search-dwell，按 SLO 判断该级别是否可持续，
+// 输出满足 SLO 的最高 QPS 和每个级别的统计。
+//   - -search-step > 0 时从 -search-min 开始按步长递增，直到某一级别不满足 SLO 或超过 -search-max
+//   - 否则二分查找：先测 -search-min 和 -search-max，再在通过和不通过的 QPS 之间二分，
+//     直到区间小于 -search-precision
+//
+// SLO 包括首包 p99 (-slo-p99-first-byte)、错误率 (-slo-error-rate) 和缓存命中率 (-slo-hit-ratio)，
+// 为 0 的项不检查；另外实际 QPS 低于目标的 searchMinAchieved 时视为压测端或目标无法承受该级别
+
+// searchMinAchieved 实际 QPS 与目标 QPS 之比的下限
+const searchMinAchieved = 0.9
+
+// levelCollector 收集一个级别内完成的请求的延迟。级别之间没有在途请求，切换时无需同步
+type levelCollector struct {
+	mu      sync.Mutex
+	latency latencyStats
+}
+
+var levelStats *levelCollector
+
+func (c *levelCollector) record(r reqStatInfo) {
+	c.mu.Lock()
+	c.latency.record(r)
+	c.mu.Unlock()
+}
+
+// searchLevel 一个 QPS 级别的压测结果
+type searchLevel struct {
+	Level         int     `json:"level"`
+	TargetQPS     int     `json:"target_qps"`
+	QPS           float64 `json:"qps"`
+	Requests      int64   `json:"requests"`
+	Errors        int64   `json:"errors"`
+	ErrorRate     float64 `json:"error_rate"`
+	Late          int64   `json:"late,omitempty"`
+	FirstByteP99  float64 `json:"first_byte_p99_ms"`
+	TotalP99      float64 `json:"total_p99_ms"`
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+	Pass          bool    `json:"pass"`
+	Reason        string  `json:"reason,omitempty"`
+}
+
+// searchReport -report-json 中的搜索结果
+type searchReport struct {
+	MaxQPS int           `json:"max_qps"` // 0 表示最低级别也不满足 SLO
+	Levels []searchLevel `json:"levels"`
+}
+
+var searchResult *searchReport
+
+// checkSLO 返回不满足的 SLO 项，为空表示通过
+func checkSLO(l *searchLevel) string {
+	var failed []string
+	if l.Requests == 0 {
+		return "没有完成的请求"
+	}
+	if l.QPS < float64(l.TargetQPS)*searchMinAchieved {
+		failed = append(failed, fmt.Sprintf("实际QPS %.1f < 目标的 %.0f%%", l.QPS, searchMinAchieved*100))
+	}
+	if config.sloP99FirstByte > 0 && l.FirstByteP99 > durationMs(config.sloP99FirstByte) {
+		failed = append(failed, fmt.Sprintf("首包p99 %.1fms > %v", l.FirstByteP99, config.sloP99FirstByte))
+	}
+	if config.sloErrorRate > 0 && l.ErrorRate > config.sloErrorRate {
+		failed = append(failed, fmt.Sprintf("错误率 %.4f > %v", l.ErrorRate, config.sloErrorRate))
+	}
+	if config.sloHitRatio > 0 && l.CacheHitRatio < config.sloHitRatio {
+		failed = append(failed, fmt.Sprintf("命中率 %.4f < %v", l.CacheHitRatio, config.sloHitRatio))
+	}
+	return strings.Join(failed, "; ")
+}
+
+// runLevel 以固定 QPS 压测 -search-dwell 并评估 SLO
+func runLevel(baseURL string, level, qps int) searchLevel {
+	fmt.Printf("\n=== 级别 %d: QPS=%d, 持续 %v ===\n", level, qps, config.searchDwell)
+	levelStats = &levelCollector{}
+	before := snapshotCounters()
+	start := time.Now()
+	if config.loadModel == loadModelOpen {
+		runOpenLoop(baseURL, start, config.searchDwell, func(time.Time) float64 { return float64(qps) })
+	} else {
+		runClosedLoop(baseURL, start, config.searchDwell, ratelimit.New(qps))
+	}
+	elapsed := time.Since(start)
+	after := snapshotCounters()
+
+	firstByte, total := levelStats.latency.all()
+	l := searchLevel{
+		Level:         level,
+		TargetQPS:     qps,
+		Requests:      after.total - before.total,
+		Late:          after.late - before.late,
+		FirstByteP99:  durationMs(firstByte.quantile(0.99)),
+		TotalP99:      durationMs(total.quantile(0.99)),
+		CacheHitRatio: ratio(levelStats.latency.hits(), levelStats.latency.count()),
+	}
+	l.QPS = float64(l.Requests) / elapsed.Seconds()
+	l.Errors = l.Requests - (after.success - before.success)
+	l.ErrorRate = ratio(l.Errors, l.Requests)
+	l.Reason = checkSLO(&l)
+	l.Pass = l.Reason == ""
+	levelStats = nil
+
+	result := "通过"
+	if !l.Pass {
+		result = "不通过: " + l.Reason
+	}
+	fmt.Printf("级别 %d: QPS=%d 实际QPS=%.1f 错误率=%.4f 首包p99=%.1fms 命中率=%.2f%% %s\n",
+		level, qps, l.QPS, l.ErrorRate, l.FirstByteP99, l.CacheHitRatio*100, result)
+	return l
+}
+
+// searchMaxQPS 按步进或二分查找满足 SLO 的最高 QPS，run 压测一个级别并返回是否通过
+func searchMaxQPS(min, max, step, precision int, run func(qps int) bool) int {
+	best := 0
+	if step > 0 {
+		for qps := min; qps <= max; qps += step {
+			if !run(qps) {
+				break
+			}
+			best = qps
+		}
+		return best
+	}
+
+	if !run(min) {
+		return 0
+	}
+	if run(max) {
+		return max
+	}
+	lo, hi := min, max // lo 通过，hi 不通过
+	for hi-lo > precision {
+		mid := lo + (hi-lo)/2
+		if run(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+func runSearch() {
+	baseURL := getBaseURL()
+	fmt.Printf("饱和点搜索: QPS %d-%d, ", config.searchMin, config.searchMax)
+	if config.searchStep > 0 {
+		fmt.Printf("步长 %d", config.searchStep)
+	} else {
+		fmt.Printf("二分查找精度 %d", config.searchPrecision)
+	}
+	fmt.Printf(", 每级持续 %v, SLO: 首包p99<%v 错误率<%v 命中率>%v\n",
+		config.searchDwell, config.sloP99FirstByte, config.sloErrorRate, config.sloHitRatio)
+
+	runWarmup(baseURL)
+	startTime := time.Now()
+	runStartTime = startTime
+	clientStat()
+
+	report := &searchReport{}
+	report.MaxQPS = searchMaxQPS(config.searchMin, config.searchMax, config.searchStep, config.searchPrecision, func(qps int) bool {
+		l := runLevel(baseURL, len(report.Levels)+1, qps)
+		report.Levels = append(report.Levels, l)
+		return l.Pass
+	})
+	searchResult = report
+
+	done <- true
+	printFinalStats(baseURL, startTime)
+	printSearchReport(report)
+	saveURLState()
+}
+
+func printSearchReport(r *searchReport) {
+	fmt.Printf("\n=== 饱和点搜索结果 ===\n")
+	fmt.Printf("  %-4s %8s %10s %10s %10s %12s %12s %8s  %s\n",
+		"级别", "目标QPS", "实际QPS", "请求数", "错误率", "首包p99", "响应p99", "命中率", "结果")
+	for _, l := range r.Levels {
+		result := "通过"
+		if !l.Pass {
+			result = "不通过: " + l.Reason
+		}
+		fmt.Printf("  %-4d %8d %10.1f %10d %10.4f %10.1fms %10.1fms %7.2f%%  %s\n",
+			l.Level, l.TargetQPS, l.QPS, l.Requests, l.ErrorRate, l.FirstByteP99, l.TotalP99, l.CacheHitRatio*100, result)
+	}
+	if r.MaxQPS == 0 {
+		fmt.Printf("最低 QPS %d 也不满足 SLO\n", config.searchMin)
+		return
+	}
+	fmt.Printf("满足 SLO 的最高 QPS: %d\n", r.MaxQPS)
+}
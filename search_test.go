@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchMaxQPS(t *testing.T) {
+	limit := 730
+	var tried []int
+	run := func(qps int) bool {
+		tried = append(tried, qps)
+		return qps <= limit
+	}
+
+	if got := searchMaxQPS(100, 2000, 0, 50, run); got < limit-50 || got > limit {
+		t.Fatalf("binary search = %d, tried %v", got, tried)
+	}
+
+	tried = nil
+	if got := searchMaxQPS(100, 2000, 200, 50, run); got != 700 {
+		t.Fatalf("step search = %d, tried %v", got, tried)
+	}
+	// 步进在第一个不通过的级别停止
+	if last := tried[len(tried)-1]; last != 900 {
+		t.Fatalf("step search stopped at %d", last)
+	}
+
+	if got := searchMaxQPS(1000, 2000, 0, 50, run); got != 0 {
+		t.Fatalf("min fails: got %d", got)
+	}
+	if got := searchMaxQPS(100, 500, 0, 50, run); got != 500 {
+		t.Fatalf("max passes: got %d", got)
+	}
+}
+
+func TestCheckSLO(t *testing.T) {
+	old := config
+	defer func() { config = old }()
+	config.sloP99FirstByte = 100 * time.Millisecond
+	config.sloErrorRate = 0.01
+	config.sloHitRatio = 0.8
+
+	l := searchLevel{TargetQPS: 100, QPS: 99, Requests: 1000, ErrorRate: 0.001, FirstByteP99: 50, CacheHitRatio: 0.9}
+	if r := checkSLO(&l); r != "" {
+		t.Fatalf("expected pass, got %q", r)
+	}
+	l.FirstByteP99 = 150
+	l.ErrorRate = 0.05
+	l.QPS = 50
+	r := checkSLO(&l)
+	for _, want := range []string{"首包p99", "错误率", "实际QPS"} {
+		if !strings.Contains(r, want) {
+			t.Errorf("reason %q missing %q", r, want)
+		}
+	}
+	if strings.Contains(r, "命中率") {
+		t.Errorf("hit ratio should pass: %q", r)
+	}
+}
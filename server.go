@@ -10,19 +10,34 @@ import (
 	"math/rand"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/andybalholm/brotli"
 )
 
-// 响应体缓存 - 键为响应大小，值为预生成的响应体
+// 响应体缓存 - 键为内容种子和响应大小，值为预生成的响应体
+type respCacheKey struct {
+	seed uint64
+	size int
+}
+
+// maxRespCacheBytes 响应体缓存的容量上限，超过后清空重新缓存
+const maxRespCacheBytes = 512 << 20
+
 var (
-	respCache      = make(map[int][]byte)
+	respCache      = make(map[respCacheKey][]byte)
+	respCacheBytes int
 	respCacheMutex sync.RWMutex
 )
 
-func serverGetRespSize(r *http.Request) int {
+// serverGetRespSize 获取响应大小，优先使用 URL 中编码的大小，其次是请求头 x-press-size
+func serverGetRespSize(r *http.Request, obj pressObject) int {
+	if obj.sized {
+		return obj.size
+	}
+
 	// 获取请求头中的 x-press-size 值
 	sizeHeader := r.Header.Get("x-press-size")
 
@@ -41,7 +56,42 @@ func serverGetRespSize(r *http.Request) int {
 	return responseSize
 }
 
+// getResponseBody 返回种子和大小对应的响应体，启用 -cache-resp 时从响应体缓存中获取
+func getResponseBody(seed uint64, size int) []byte {
+	if !config.cacheResp {
+		// 不使用缓存，临时生成
+		return genContent(seed, size)
+	}
+	key := respCacheKey{seed: seed, size: size}
+	respCacheMutex.RLock()
+	body, ok := respCache[key]
+	respCacheMutex.RUnlock()
+	if ok {
+		return body
+	}
+
+	// 缓存中不存在，生成并添加到缓存
+	body = genContent(seed, size)
+	respCacheMutex.Lock()
+	// 再次检查，避免竞态条件
+	if _, ok := respCache[key]; !ok {
+		if respCacheBytes+len(body) > maxRespCacheBytes {
+			respCache = make(map[respCacheKey][]byte)
+			respCacheBytes = 0
+		}
+		respCache[key] = body
+		respCacheBytes += len(body)
+	}
+	respCacheMutex.Unlock()
+	return body
+}
+
 func serverHandler(w http.ResponseWriter, r *http.Request) {
+	if config.sliceSize > 0 && strings.HasSuffix(r.URL.Path, sliceManifestSuffix) {
+		serveSliceManifest(w, r)
+		return
+	}
+
 	// 记录请求开始时间
 	startTime := time.Now()
 	if config.delayRespHdr > 0 {
@@ -58,139 +108,87 @@ func serverHandler(w http.ResponseWriter, r *http.Request) {
 		traceID = "unknown"
 	}
 
+	if config.hdrDigest {
+		setRequestHeadersDigest(w, r, traceID)
+	}
+
 	// 获取请求信息
 	method := r.Method
 	host := r.Host
 	url := r.URL.String()
 	contentLength := r.ContentLength
 
-	responseSize := serverGetRespSize(r)
-	/*
-		// 检查是否为 Range 请求
-		rangeHeader := r.Header.Get("Range")
-		if rangeHeader != "" {
-			// 解析 Range 头部，格式如 "bytes=0-499"
-			if strings.HasPrefix(rangeHeader, "bytes=") {
-				rangeValue := strings.TrimPrefix(rangeHeader, "bytes=")
-				if strings.Contains(rangeValue, "-") {
-					parts := strings.Split(rangeValue, "-")
-					start, err1 := strconv.ParseInt(parts[0], 10, 64)
-					end := int64(responseSize - 1)
-					if parts[1] != "" {
-						end, err2 := strconv.ParseInt(parts[1], 10, 64)
-						if err2 != nil {
-							end = int64(responseSize - 1)
-						}
-						if end >= int64(responseSize) {
-							end = int64(responseSize - 1)
-						}
-					}
-
-					if err1 == nil && start <= end && start < int64(responseSize) {
-						// 有效 Range 请求
-						rangeSize := int(end-start+1)
-						rangeBodyPtr := buffer.GetBytes(1024)
-						defer buffer.PutBytes(rangeBodyPtr)
-						rangeBody := *rangeBodyPtr
-
-						// 如果需要的长度超过池中切片的容量，创建一个新的
-						if rangeSize > cap(rangeBody) {
-							rangeBody = make([]byte, rangeSize)
-						} else {
-							rangeBody = rangeBody[:rangeSize]
-						}
-
-						for i := range rangeBody {
-							rangeBody[i] = 'x'
-						}
-
-						// 记录头部发送时间
-						headerSendTime := time.Now()
-
-						// 设置 Range 响应头
-						w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, responseSize))
-						w.Header().Set("Content-Length", strconv.Itoa(len(rangeBody)))
-						w.Header().Set("Content-Type", "application/octet-stream")
-						w.WriteHeader(http.StatusPartialContent)
-
-						// 发送部分响应
-						w.Write(rangeBody)
-
-						// 记录body完成时间
-						bodyCompleteTime := time.Now()
-
-						fmt.Printf("Range响应 - Trace-ID: %s, Host: %s, URL: %s, Method: %s, Content-Length: %d, Start: %s, HeaderSent: %s, BodyComplete: %s, Range: %s, BodyLength: %d\n",
-							traceID, host, url, method, contentLength,
-							startTime.Format("2006-01-02 15:04:05.000"),
-							headerSendTime.Format("2006-01-02 15:04:05.000"),
-							bodyCompleteTime.Format("2006-01-02 15:04:05.000"),
-							rangeHeader, len(rangeBody))
-						return
-					}
-				}
-			}
-		}
-	*/
-	// 生成响应体
-	var responseBody []byte
-	if config.cacheResp {
-		// 使用响应体缓存
-		respCacheMutex.RLock()
-		var ok bool
-		responseBody, ok = respCache[responseSize]
-		respCacheMutex.RUnlock()
-
-		if !ok {
-			// 缓存中不存在，生成并添加到缓存
-			newBody := bytes.Repeat([]byte("x"), responseSize)
-			respCacheMutex.Lock()
-			// 再次检查，避免竞态条件
-			if _, ok := respCache[responseSize]; !ok {
-				respCache[responseSize] = newBody
-			}
-			respCacheMutex.Unlock()
-			responseBody = newBody
-		}
-	} else {
-		// 不使用缓存，临时生成
-		responseBody = bytes.Repeat([]byte("x"), responseSize)
+	obj := serverGetObject(r)
+	responseSize := serverGetRespSize(r, obj)
+
+	// 按 host + path + 版本号生成确定性的响应体
+	seed := contentSeed(contentHost(host), r.URL.Path, obj.version)
+	responseBody := getResponseBody(seed, responseSize)
+
+	// 设置基础响应头
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("X-Press-Version", strconv.FormatInt(obj.version, 10))
+	if config.sliceSize > 0 {
+		setSliceDigestHeader(w, r, seed, responseBody)
 	}
 
-	// 根据客户端 Accept-Encoding 决定是否压缩（支持 gzip 和 br）
-	ae := r.Header.Get("Accept-Encoding")
-	var compressedBody []byte
-	encoding := ""
+	setCacheHeaders(w, r, obj, startTime)
 
-	// 生成响应体（未压缩）
-	// responseBody 已生成上方
+	// 根据客户端 Accept-Encoding 选择完整响应的编码（优先顺序： br -> gzip ），Range 响应不压缩
+	selectedEncoding := selectEncoding(r.Header.Get("Accept-Encoding"))
 
-	if config.delayRespBody > 0 {
-		delay := config.delayRespBody
-		if config.delayRespBodyRandom > 0 {
-			delay += rand.Intn(config.delayRespBodyRandom)
-		}
-		time.Sleep(time.Duration(delay) * time.Millisecond)
+	// ETag / Last-Modified 由 URL 和版本号确定，条件请求命中时返回 304。
+	// 不同编码的响应体不同，ETag 也带上编码，避免 CDN 用一个编码的校验值重新验证另一个编码
+	etag := objectETag(seed, responseSize, selectedEncoding)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	lastModified := objectLastModified(seed, obj.version)
+	if config.lastModified {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
 	}
 
-	// 设置基础响应头
-	w.Header().Set("Content-Type", "application/octet-stream")
-
-	// 如果启用MD5校验，计算响应内容的MD5并添加到响应头
-	if config.enableMD5 {
-		var dataToHash []byte
-		if encoding != "" {
-			// 如果使用压缩，计算压缩后数据的MD5
-			dataToHash = compressedBody
-		} else {
-			// 否则计算原始响应体的MD5
-			dataToHash = responseBody
+	// 处理 Range 请求，payload 为最终发送的响应体
+	status := http.StatusOK
+	payload := responseBody
+	if checkNotModified(r, etag, lastModified) {
+		status = http.StatusNotModified
+		payload = nil
+	}
+	// 错误注入优先于条件请求和 Range
+	if faultStatus := faults.pick(r.URL.Path); faultStatus != 0 {
+		status = faultStatus
+		payload = faults.setFaultHeaders(w, faultStatus)
+	}
+	// Range 片段取自未压缩的内容，If-Range 与未压缩响应的 ETag 比较，206/416 响应也使用该 ETag
+	rangeHeader := r.Header.Get("Range")
+	identityETag := objectETag(seed, responseSize, "")
+	if rangeHeader != "" && status == http.StatusOK && (method == http.MethodGet || method == http.MethodHead) &&
+		checkIfRange(r.Header.Get("If-Range"), identityETag, w.Header().Get("Last-Modified")) {
+		objectSize := int64(len(responseBody))
+		ranges, err := parseRange(rangeHeader, objectSize)
+		switch {
+		case err == errNoOverlap:
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", objectSize))
+			status = http.StatusRequestedRangeNotSatisfiable
+			payload = nil
+		case err != nil || len(ranges) == 0 || sumRangesSize(ranges) > objectSize:
+			// 语法错误或区间总和超过对象大小时忽略 Range，返回完整内容
+		case len(ranges) == 1:
+			ra := ranges[0]
+			w.Header().Set("Content-Range", ra.contentRange(objectSize))
+			status = http.StatusPartialContent
+			payload = responseBody[ra.start : ra.start+ra.length]
+		default:
+			var buf bytes.Buffer
+			w.Header().Set("Content-Type", writeMultipartRanges(&buf, responseBody, ranges, "application/octet-stream"))
+			status = http.StatusPartialContent
+			payload = buf.Bytes()
+		}
+		if identityETag != "" && status != http.StatusOK {
+			w.Header().Set("ETag", identityETag)
 		}
-
-		hasher := md5.New()
-		hasher.Write(dataToHash)
-		md5Sum := hex.EncodeToString(hasher.Sum(nil))
-		w.Header().Set("X-Content-MD5", md5Sum)
-		fmt.Printf("MD5校验已启用，响应大小: %d, MD5: %s\n", len(dataToHash), md5Sum)
 	}
 
 	// 根据keepAliveProb设置Connection头
@@ -200,50 +198,159 @@ func serverHandler(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Connection", "close")
 	}
 
-	// 选择压缩算法（优先顺序： br -> gzip ）
-	if ae != "" {
-		// 简单判断是否包含子串
-		if bytes.Contains([]byte(ae), []byte("br")) {
-			// brotli
-			var buf bytes.Buffer
+	// 只压缩完整的 200 响应
+	encoding := ""
+	if status == http.StatusOK && selectedEncoding != "" {
+		var buf bytes.Buffer
+		switch selectedEncoding {
+		case "br":
 			bw := brotli.NewWriter(&buf)
 			_, _ = bw.Write(responseBody)
 			_ = bw.Close()
-			compressedBody = buf.Bytes()
-			encoding = "br"
-		} else if bytes.Contains([]byte(ae), []byte("gzip")) {
-			var buf bytes.Buffer
+		case "gzip":
 			gw := gzip.NewWriter(&buf)
 			_, _ = gw.Write(responseBody)
 			_ = gw.Close()
-			compressedBody = buf.Bytes()
-			encoding = "gzip"
 		}
+		payload = buf.Bytes()
+		encoding = selectedEncoding
 	}
 
-	// 设置 Content-Length 和 Content-Encoding（如果压缩）
-	if encoding != "" {
-		// 告知客户端缓存变体：基于 Accept-Encoding
+	// 设置 Content-Encoding（如果压缩），304 响应同样告知客户端缓存变体：基于 Accept-Encoding
+	if selectedEncoding != "" && (status == http.StatusOK || status == http.StatusNotModified) {
 		w.Header().Set("Vary", "Accept-Encoding")
+	}
+	if encoding != "" {
 		w.Header().Set("Content-Encoding", encoding)
-		w.Header().Set("Content-Length", strconv.Itoa(len(compressedBody)))
-	} else {
-		w.Header().Set("Content-Length", strconv.Itoa(responseSize))
+	}
+	if status != http.StatusNotModified {
+		w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+	}
+
+	// 响应体中途故障只注入到有响应体的 200/206 响应，cutoff 为故障前写出的字节数
+	bodyFault, cutoff := "", len(payload)
+	if method != http.MethodHead && (status == http.StatusOK || status == http.StatusPartialContent) {
+		if kind, n := bodyFaults.pick(len(payload)); kind != "" {
+			bodyFault, cutoff = kind, n
+			serverMetrics.bodyFaults.inc(kind)
+		}
+	}
+	// 按 -chunk-resp 的比例以 chunked 编码发送，HTTP/1.0 不支持 chunked
+	chunked := bodyFault == bodyFaultNoTerm
+	if !chunked && config.chunkResp > 0 && method != http.MethodHead && len(payload) > 0 &&
+		(status == http.StatusOK || status == http.StatusPartialContent) && r.ProtoAtLeast(1, 1) {
+		chunked = rand.Float64() < config.chunkResp
+	}
+	if chunked {
+		// 去掉 Content-Length 并显式声明 chunked，避免 Go 为较小的响应体自动补上 Content-Length
+		w.Header().Del("Content-Length")
+		w.Header().Set("Transfer-Encoding", "chunked")
+		if config.chunkTrailer {
+			w.Header().Set("Trailer", chunkTrailerName)
+		}
+	}
+
+	// 如果启用MD5校验，计算实际发送内容（压缩后或Range片段）的MD5并添加到响应头
+	if config.enableMD5 {
+		hasher := md5.New()
+		hasher.Write(payload)
+		md5Sum := hex.EncodeToString(hasher.Sum(nil))
+		w.Header().Set("X-Content-MD5", md5Sum)
+		fmt.Printf("MD5校验已启用，响应大小: %d, MD5: %s\n", len(payload), md5Sum)
+	}
+
+	if config.hdrDigest {
+		setResponseHeadersDigest(w)
 	}
 
 	// 记录头部发送时间
 	headerSendTime := time.Now()
 
-	// 发送响应（压缩或未压缩）
+	// 发送响应（压缩、未压缩或Range片段）
+	w.WriteHeader(status)
+	// HEAD 响应没有响应体，不延迟、不限速，也不计入发送字节数
+	var pacer *bodyPacer
+	var written int
+	var writeErr error
+	if method != http.MethodHead && len(payload) > 0 {
+		// 响应头先发出，之后再延迟和限速发送响应体
+		if config.delayRespBody > 0 {
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			delay := config.delayRespBody
+			if config.delayRespBodyRandom > 0 {
+				delay += rand.Intn(config.delayRespBodyRandom)
+			}
+			time.Sleep(time.Duration(delay) * time.Millisecond)
+		}
+		pacer = newBodyPacer()
+		written, writeErr = writeBody(w, payload[:cutoff], chunked, pacer)
+	}
+	if chunked && config.chunkTrailer && bodyFault == "" {
+		sum := md5.Sum(payload)
+		w.Header().Set(chunkTrailerName, hex.EncodeToString(sum[:]))
+	}
+
+	// 记录body完成时间
+	bodyCompleteTime := time.Now()
+	closeConn := bodyFault == "" && config.closeConnAfterBodyProb > 0 && rand.Float64() <= config.closeConnAfterBodyProb
+
+	serverMetrics.requests.inc(strconv.Itoa(status), encoding)
+	if status == http.StatusOK || status == http.StatusNotModified {
+		revalidations.record(r.URL.Path, status == http.StatusNotModified)
+	}
+	serverMetrics.bySize.inc(sizeBucketLabel(responseSize))
 	if encoding != "" {
-		_, _ = w.Write(compressedBody)
-	} else {
-		_, _ = w.Write(responseBody)
+		serverMetrics.compressed.inc(encoding)
+	}
+	serverMetrics.response.observeDuration(bodyCompleteTime.Sub(startTime))
+	serverMetrics.bytesIn.add(headerBytes(r.Header) + max(contentLength, 0))
+	serverMetrics.bytesOut.add(int64(written))
+
+	// 访问日志在主动关闭连接之前记录，时间戳只反映响应本身
+	if serverAccessLog != nil {
+		connID, connSeq := nextConnRequest(r)
+		entry := &accessLogEntry{
+			Time:          formatLogTime(bodyCompleteTime),
+			TraceID:       traceID,
+			RemoteAddr:    r.RemoteAddr,
+			XForwardedFor: r.Header.Get("X-Forwarded-For"),
+			ConnID:        connID,
+			ConnSeq:       connSeq,
+			Method:        method,
+			Host:          host,
+			URI:           url,
+			Proto:         r.Proto,
+			Range:         rangeHeader,
+			Status:        status,
+			Encoding:      encoding,
+			ObjectSize:    responseSize,
+			BodyLength:    len(payload),
+			BytesSent:     int64(written),
+			Start:         formatLogTime(startTime),
+			HeaderSent:    formatLogTime(headerSendTime),
+			BodyComplete:  formatLogTime(bodyCompleteTime),
+			DurationMs:    durationMs(bodyCompleteTime.Sub(startTime)),
+			ConnClose:     closeConn,
+			BodyFault:     bodyFault,
+			Chunked:       chunked,
+			BodyRate:      pacer.logRate(),
+		}
+		if writeErr != nil {
+			entry.WriteError = writeErr.Error()
+		}
+		serverAccessLog.log(entry)
 	}
 
-	// 根据closeConnAfterBodyProb决定是否主动关闭连接
-	if config.closeConnAfterBodyProb > 0 && rand.Float64() <= config.closeConnAfterBodyProb {
-		// 尝试获取底层连接并关闭
+	// 注入响应体故障，或根据closeConnAfterBodyProb决定是否主动关闭连接
+	if bodyFault != "" {
+		abortConn(w, bodyFault)
+	} else if closeConn {
+		// 先把缓冲的响应体发出去再获取底层连接并关闭
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
 		if hj, ok := w.(http.Hijacker); ok {
 			conn, _, err := hj.Hijack()
 			if err == nil {
@@ -251,23 +358,56 @@ func serverHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-
-	// 记录body完成时间
-	bodyCompleteTime := time.Now()
-
-	fmt.Printf("响应完成 - Trace-ID: %s, Host: %s, URL: %s, Method: %s, Content-Length: %d, Start: %s, HeaderSent: %s, BodyComplete: %s, BodyLength: %d\n",
-		traceID, host, url, method, contentLength,
-		startTime.Format("2006-01-02 15:04:05.000"),
-		headerSendTime.Format("2006-01-02 15:04:05.000"),
-		bodyCompleteTime.Format("2006-01-02 15:04:05.000"),
-		len(responseBody))
 }
 
 func startServer() {
 	addr := fmt.Sprintf(":%d", config.port)
 	fmt.Printf("启动服务器在端口 %s\n", addr)
-	fmt.Printf("服务器将根据请求头 x-press-size 的值返回对应大小的响应体\n")
+	fmt.Printf("服务器将根据 URL 中编码的大小 (如 /path1_s1024.js) 或请求头 x-press-size 的值返回对应大小的响应体\n")
+
+	var err error
+	cachePolicies, err = loadCachePolicies(config.cachePolicyFile, config.cachePolicy)
+	if err != nil {
+		log.Fatal("无效的缓存策略: ", err)
+	}
+	for i, p := range cachePolicies {
+		fmt.Printf("缓存策略规则 %d: %s => Cache-Control: %q, Surrogate-Control: %q", i+1, p.pattern, p.cacheControl, p.surrogate)
+		if p.expires >= 0 {
+			fmt.Printf(", Expires: +%ds", p.expires)
+		}
+		fmt.Println()
+	}
+
+	faults, err = newFaultInjector(config.fault, config.faultRules, config.faultBodySize)
+	if err != nil {
+		log.Fatal("无效的错误注入参数: ", err)
+	}
+
+	chunkSizes, err = parseChunkSize(config.chunkSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	bodyFaults, err = parseBodyFaults(config.bodyFault, config.bodyFaultAt)
+	if err != nil {
+		log.Fatal("无效的响应体故障参数: ", err)
+	}
+	bodyRates, err = parseBodyRate(config.bodyRate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if config.bodyRateGlobal > 0 {
+		globalBodyLimiter = newByteLimiter(config.bodyRateGlobal)
+	}
+
+	initServerMetrics()
+	initAccessLog()
+	startMetricsServer(map[string]http.Handler{"/revalidations": revalidations})
 
 	http.HandleFunc("/", serverHandler)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	server := &http.Server{
+		Addr:        addr,
+		ConnState:   serverMetrics.connState,
+		ConnContext: withConnInfo,
+	}
+	log.Fatal(server.ListenAndServe())
 }
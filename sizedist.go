@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sizeDist 对象大小分布。size 按 [0,1) 区间的均匀分布值做逆 CDF 采样，
+// 同一个 u 总是得到同一个大小，因此同一个 URL id 的大小是确定的。
+type sizeDist interface {
+	size(u float64) int
+	String() string
+}
+
+// respSizeDist 客户端使用的对象大小分布
+var respSizeDist sizeDist
+
+// fixedSize 固定大小
+type fixedSize struct {
+	n int
+}
+
+func (d fixedSize) size(float64) int { return d.n }
+func (d fixedSize) String() string   { return fmt.Sprintf("fixed(%d)", d.n) }
+
+// minMaxSize 兼容 -resp-size=[min,max] 与 -disk-ratio：按比例取两个端点之一
+type minMaxSize struct {
+	min, max int
+	ratio    float64
+}
+
+func (d minMaxSize) size(u float64) int {
+	if u < d.ratio {
+		return d.min
+	}
+	return d.max
+}
+
+func (d minMaxSize) String() string {
+	return fmt.Sprintf("minmax(%d,%d, 小响应体比例=%.2f)", d.min, d.max, d.ratio)
+}
+
+// uniformSize [min,max] 区间均匀分布
+type uniformSize struct {
+	min, max int
+}
+
+func (d uniformSize) size(u float64) int {
+	return d.min + int(u*float64(d.max-d.min+1))
+}
+
+func (d uniformSize) String() string { return fmt.Sprintf("uniform(%d,%d)", d.min, d.max) }
+
+// lognormalSize 对数正态分布，按中位数和 sigma 配置，可选截断到 [min,max]
+type lognormalSize struct {
+	median   float64
+	sigma    float64
+	min, max int
+}
+
+func (d lognormalSize) size(u float64) int {
+	// 标准正态分布的逆 CDF
+	z := math.Sqrt2 * math.Erfinv(2*u-1)
+	// 未指定 max 时 u 接近 1 的样本可能为 +Inf 或超出 int 范围，限制在源站响应体缓存的容量以内
+	v := d.median * math.Exp(d.sigma*z)
+	if math.IsNaN(v) || v < 0 {
+		v = 0
+	}
+	v = math.Min(v, maxRespCacheBytes)
+	return clampSize(int(math.Round(v)), d.min, d.max)
+}
+
+func (d lognormalSize) String() string {
+	return fmt.Sprintf("lognormal(median=%.0f,sigma=%.2f,min=%d,max=%d)", d.median, d.sigma, d.min, d.max)
+}
+
+// paretoSize 有界 Pareto 分布
+type paretoSize struct {
+	alpha    float64
+	min, max int
+}
+
+func (d paretoSize) size(u float64) int {
+	l, h := float64(d.min), float64(d.max)
+	x := l / math.Pow(1-u*(1-math.Pow(l/h, d.alpha)), 1/d.alpha)
+	return clampSize(int(math.Round(x)), d.min, d.max)
+}
+
+func (d paretoSize) String() string {
+	return fmt.Sprintf("pareto(alpha=%.2f,min=%d,max=%d)", d.alpha, d.min, d.max)
+}
+
+// bucketSize 按权重选择固定大小的桶；interpolate 为 true 时在相邻桶之间线性插值（经验 CDF）
+type bucketSize struct {
+	name        string
+	sizes       []int
+	cdf         []float64
+	interpolate bool
+}
+
+func newBucketSize(name string, sizes []int, weights []float64, interpolate bool) (*bucketSize, error) {
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("%s: 没有任何大小桶", name)
+	}
+	idx := make([]int, len(sizes))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return sizes[idx[a]] < sizes[idx[b]] })
+
+	d := &bucketSize{name: name, interpolate: interpolate}
+	var total float64
+	for _, i := range idx {
+		if err := checkSizes(name, float64(sizes[i])); err != nil {
+			return nil, err
+		}
+		if !(weights[i] >= 0 && !math.IsInf(weights[i], 1)) {
+			return nil, fmt.Errorf("%s: 权重应为非负的有限数值", name)
+		}
+		total += weights[i]
+		d.sizes = append(d.sizes, sizes[i])
+		d.cdf = append(d.cdf, total)
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("%s: 权重之和必须大于0", name)
+	}
+	for i := range d.cdf {
+		d.cdf[i] /= total
+	}
+	return d, nil
+}
+
+func (d *bucketSize) size(u float64) int {
+	i := sort.SearchFloat64s(d.cdf, u)
+	for i < len(d.cdf)-1 && d.cdf[i] == u {
+		// SearchFloat64s 返回 cdf[i] >= u，等于时落入下一个桶
+		i++
+	}
+	if i >= len(d.sizes) {
+		i = len(d.sizes) - 1
+	}
+	if !d.interpolate || i == 0 {
+		return d.sizes[i]
+	}
+	lo, hi := d.cdf[i-1], d.cdf[i]
+	frac := (u - lo) / (hi - lo)
+	return d.sizes[i-1] + int(frac*float64(d.sizes[i]-d.sizes[i-1]))
+}
+
+func (d *bucketSize) String() string {
+	parts := make([]string, len(d.sizes))
+	prev := 0.0
+	for i, s := range d.sizes {
+		parts[i] = fmt.Sprintf("%d:%.3f", s, d.cdf[i]-prev)
+		prev = d.cdf[i]
+	}
+	if len(parts) > 8 {
+		parts = append(parts[:4], "...", parts[len(parts)-1])
+	}
+	return fmt.Sprintf("%s(%s)", d.name, strings.Join(parts, ","))
+}
+
+// checkSizes 检查分布参数中的大小：不能为 NaN、无穷或负数，且不超过 maxRespCacheBytes，
+// 避免转换为 int 时溢出，或者让源站按 URL 中的大小分配过大的内存
+func checkSizes(kind string, sizes ...float64) error {
+	for _, f := range sizes {
+		if math.IsNaN(f) || math.IsInf(f, 0) || f < 0 || f > maxRespCacheBytes {
+			return fmt.Errorf("%s: 无效的大小 %v，应在 0-%d 之间", kind, f, maxRespCacheBytes)
+		}
+	}
+	return nil
+}
+
+// checkParam 检查分布的形状参数（sigma、alpha 等）为正的有限数值
+func checkParam(kind, name string, f float64) error {
+	if !(f > 0 && !math.IsInf(f, 1)) {
+		return fmt.Errorf("%s: %s 应为正的有限数值", kind, name)
+	}
+	return nil
+}
+
+func clampSize(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if max > 0 && n > max {
+		return max
+	}
+	return n
+}
+
+func parseFloats(s string) ([]float64, error) {
+	var fs []float64
+	for _, p := range strings.Split(s, ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效的数值 %q", p)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// parseSizeDist 解析 -size-dist 参数，为空时使用 -resp-size 与 -disk-ratio。支持的格式:
+//
+//	fixed:1024
+//	uniform:1024,1048576
+//	lognormal:中位数,sigma[,min,max]
+//	pareto:alpha,min,max
+//	buckets:1024:0.5,65536:0.3,1048576:0.2
+//	empirical:sizes.csv    (每行 size,count，在相邻大小之间线性插值)
+func parseSizeDist(spec string, sizeRange []int, ratio float64) (sizeDist, error) {
+	if spec == "" {
+		for _, n := range sizeRange {
+			if err := checkSizes("resp-size", float64(n)); err != nil {
+				return nil, err
+			}
+		}
+		if len(sizeRange) == 1 {
+			return fixedSize{sizeRange[0]}, nil
+		}
+		return minMaxSize{sizeRange[0], sizeRange[1], ratio}, nil
+	}
+
+	kind, args, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "fixed":
+		n, err := strconv.Atoi(args)
+		if err != nil {
+			return nil, fmt.Errorf("fixed: 无效的大小 %q", args)
+		}
+		if err := checkSizes(kind, float64(n)); err != nil {
+			return nil, err
+		}
+		return fixedSize{n}, nil
+	case "uniform":
+		fs, err := parseFloats(args)
+		if err != nil || len(fs) != 2 {
+			return nil, fmt.Errorf("uniform: 格式应为 uniform:min,max")
+		}
+		if err := checkSizes(kind, fs...); err != nil {
+			return nil, err
+		}
+		if fs[1] < fs[0] {
+			return nil, fmt.Errorf("uniform: max 不能小于 min")
+		}
+		return uniformSize{int(fs[0]), int(fs[1])}, nil
+	case "lognormal":
+		fs, err := parseFloats(args)
+		if err != nil || (len(fs) != 2 && len(fs) != 4) {
+			return nil, fmt.Errorf("lognormal: 格式应为 lognormal:中位数,sigma[,min,max]")
+		}
+		if err := checkSizes(kind, append([]float64{fs[0]}, fs[2:]...)...); err != nil {
+			return nil, err
+		}
+		if err := checkParam(kind, "中位数", fs[0]); err != nil {
+			return nil, err
+		}
+		if err := checkParam(kind, "sigma", fs[1]); err != nil {
+			return nil, err
+		}
+		d := lognormalSize{median: fs[0], sigma: fs[1]}
+		if len(fs) == 4 {
+			if fs[3] < fs[2] {
+				return nil, fmt.Errorf("lognormal: max 不能小于 min")
+			}
+			d.min, d.max = int(fs[2]), int(fs[3])
+		}
+		return d, nil
+	case "pareto":
+		fs, err := parseFloats(args)
+		if err != nil || len(fs) != 3 {
+			return nil, fmt.Errorf("pareto: 格式应为 pareto:alpha,min,max")
+		}
+		if err := checkParam(kind, "alpha", fs[0]); err != nil {
+			return nil, err
+		}
+		if err := checkSizes(kind, fs[1:]...); err != nil {
+			return nil, err
+		}
+		if fs[1] <= 0 || fs[2] <= fs[1] {
+			return nil, fmt.Errorf("pareto: 应满足 0 < min < max")
+		}
+		return paretoSize{alpha: fs[0], min: int(fs[1]), max: int(fs[2])}, nil
+	case "buckets":
+		var sizes []int
+		var weights []float64
+		for _, p := range strings.Split(args, ",") {
+			sizeStr, weightStr, ok := strings.Cut(strings.TrimSpace(p), ":")
+			size, err1 := strconv.Atoi(sizeStr)
+			weight, err2 := strconv.ParseFloat(weightStr, 64)
+			if !ok || err1 != nil || err2 != nil || size < 0 {
+				return nil, fmt.Errorf("buckets: 无效的桶 %q，格式应为 size:weight", p)
+			}
+			sizes = append(sizes, size)
+			weights = append(weights, weight)
+		}
+		return newBucketSize("buckets", sizes, weights, false)
+	case "empirical":
+		return loadEmpiricalSize(args)
+	}
+	return nil, fmt.Errorf("未知的大小分布 %q", kind)
+}
+
+// loadEmpiricalSize 从 CSV 文件加载经验分布，每行为 size,count；无法解析的行（如表头、注释）会被跳过
+func loadEmpiricalSize(file string) (sizeDist, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sizes []int
+	var weights []float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sizeStr, countStr, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ",")
+		if !ok {
+			continue
+		}
+		size, err1 := strconv.Atoi(strings.TrimSpace(sizeStr))
+		count, err2 := strconv.ParseFloat(strings.TrimSpace(countStr), 64)
+		if err1 != nil || err2 != nil || size < 0 {
+			continue
+		}
+		sizes = append(sizes, size)
+		weights = append(weights, count)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return newBucketSize("empirical:"+file, sizes, weights, true)
+}
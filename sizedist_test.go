@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// sampleMedian 按均匀网格采样分布并返回中位数和取值范围
+func sampleMedian(d sizeDist) (median, min, max int) {
+	const n = 10001
+	sizes := make([]int, n)
+	for i := range sizes {
+		sizes[i] = d.size((float64(i) + 0.5) / n)
+	}
+	sort.Ints(sizes)
+	return sizes[n/2], sizes[0], sizes[n-1]
+}
+
+func TestParseSizeDist(t *testing.T) {
+	cases := []struct {
+		spec               string
+		median, min, max   int
+		medianTolerancePct int
+	}{
+		{"fixed:4096", 4096, 4096, 4096, 0},
+		{"uniform:1000,2000", 1500, 1000, 2000, 1},
+		{"lognormal:10000,1.5,100,10000000", 10000, 100, 10000000, 2},
+		{"pareto:1.2,1024,1048576", 1820, 1024, 1048576, 3},
+		{"buckets:1024:0.3,65536:0.7", 65536, 1024, 65536, 0},
+	}
+	for _, c := range cases {
+		d, err := parseSizeDist(c.spec, nil, 0)
+		if err != nil {
+			t.Fatalf("parseSizeDist(%q): %v", c.spec, err)
+		}
+		median, min, max := sampleMedian(d)
+		tol := c.median * c.medianTolerancePct / 100
+		if median < c.median-tol || median > c.median+tol {
+			t.Errorf("%s: median = %d, want %d±%d", c.spec, median, c.median, tol)
+		}
+		if min < c.min || max > c.max {
+			t.Errorf("%s: range [%d,%d] outside [%d,%d]", c.spec, min, max, c.min, c.max)
+		}
+	}
+
+	for _, spec := range []string{"uniform:5", "pareto:1,10,5", "buckets:1024", "zipf:1", "lognormal:0,1"} {
+		if _, err := parseSizeDist(spec, nil, 0); err == nil {
+			t.Errorf("parseSizeDist(%q) should fail", spec)
+		}
+	}
+
+	d, err := parseSizeDist("", []int{100, 200}, 0.25)
+	if err != nil || d.size(0.2) != 100 || d.size(0.3) != 200 {
+		t.Errorf("legacy [min,max] distribution not honoured: %v %v", d, err)
+	}
+}
+
+func TestParseSizeDistBounds(t *testing.T) {
+	// NaN、无穷、负数和超过 maxRespCacheBytes 的大小在所有分布中都应被拒绝
+	for _, spec := range []string{
+		"fixed:536870913",
+		"fixed:-1",
+		"uniform:0,1e19",
+		"uniform:0,536870913",
+		"uniform:NaN,100",
+		"uniform:-Inf,100",
+		"lognormal:NaN,1",
+		"lognormal:1e19,1",
+		"lognormal:1000,Inf",
+		"lognormal:1000,NaN",
+		"lognormal:1000,1,-5,100",
+		"lognormal:1000,1,100,10",
+		"lognormal:1000,1,0,1e19",
+		"pareto:NaN,1,10",
+		"pareto:Inf,1,10",
+		"pareto:1.2,1,1e30",
+		"pareto:1.2,1024,536870913",
+		"pareto:1.2,NaN,10",
+		"buckets:1024:Inf,2048:1",
+		"buckets:1024:NaN,2048:1",
+		"buckets:536870913:1",
+	} {
+		if _, err := parseSizeDist(spec, nil, 0); err == nil {
+			t.Errorf("parseSizeDist(%q) should fail", spec)
+		}
+	}
+	if _, err := parseSizeDist("", []int{1024, 1 << 30}, 0.5); err == nil {
+		t.Error("-resp-size above the limit should fail")
+	}
+	if _, err := parseSizeDist("fixed:536870912", nil, 0); err != nil {
+		t.Errorf("size at the limit: %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "sizes.csv")
+	for _, data := range []string{"1000,Inf\n2000,1\n", "1000,1\n536870913,1\n"} {
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseSizeDist("empirical:"+file, nil, 0); err == nil {
+			t.Errorf("empirical %q should fail", data)
+		}
+	}
+}
+
+func TestLognormalSizeBounded(t *testing.T) {
+	// 没有 max 时，u 接近 1 的样本不能溢出为负数或超过上限
+	d := lognormalSize{median: 1 << 20, sigma: 50}
+	for _, u := range []float64{0, 1e-300, 0.5, 1 - 1e-16, 1} {
+		if n := d.size(u); n < 0 || n > maxRespCacheBytes {
+			t.Errorf("size(%v) = %d", u, n)
+		}
+	}
+	if n := d.size(1); n != maxRespCacheBytes {
+		t.Errorf("size(1) = %d, want %d", n, maxRespCacheBytes)
+	}
+}
+
+func TestEmpiricalSize(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sizes.csv")
+	data := "size,count\n1000,50\n2000,0\n3000,50\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := parseSizeDist("empirical:"+file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.size(0.25); got != 1000 {
+		t.Errorf("size(0.25) = %d, want 1000", got)
+	}
+	if got := d.size(0.75); got != 2500 {
+		t.Errorf("size(0.75) = %d, want 2500", got)
+	}
+}
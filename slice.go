@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 分片 MD5 校验：CDN 按固定大小分片回源和缓存时，单个分片出错很难从整体 MD5 中定位。
+// 源站按 -slice-size 将完整对象（未压缩）切分为若干分片，分片数不超过 -slice-md5-max 时
+// 在 X-Press-Slice-MD5 中直接返回 "<分片大小>;<md5>,<md5>,..."，否则在 X-Press-Slice-Manifest
+// 中返回 "<分片大小>;<清单路径>"，清单路径为对象路径加 .slices 后缀。
+// 客户端按对象内的绝对偏移计算收到的每个完整分片的 MD5，Range 响应首尾不完整的分片不参与校验。
+const (
+	sliceMD5HeaderName      = "X-Press-Slice-MD5"
+	sliceManifestHeaderName = "X-Press-Slice-Manifest"
+	sliceManifestSuffix     = ".slices"
+)
+
+// maxSliceDigestEntries 源站分片摘要缓存的对象数上限，超过后清空重新缓存
+const maxSliceDigestEntries = 100000
+
+var (
+	sliceDigestCache      = make(map[respCacheKey][]string)
+	sliceDigestCacheMutex sync.RWMutex
+)
+
+// sliceDigests 计算 body 按 sliceSize 切分后每个分片的 MD5
+func sliceDigests(body []byte, sliceSize int) []string {
+	digests := make([]string, 0, (len(body)+sliceSize-1)/sliceSize)
+	for off := 0; off < len(body); off += sliceSize {
+		end := min(off+sliceSize, len(body))
+		sum := md5.Sum(body[off:end])
+		digests = append(digests, hex.EncodeToString(sum[:]))
+	}
+	return digests
+}
+
+// objectSliceDigests 返回对象的分片摘要，启用 -cache-resp 时与响应体一样缓存
+func objectSliceDigests(seed uint64, body []byte) []string {
+	if !config.cacheResp {
+		return sliceDigests(body, config.sliceSize)
+	}
+	key := respCacheKey{seed: seed, size: len(body)}
+	sliceDigestCacheMutex.RLock()
+	digests, ok := sliceDigestCache[key]
+	sliceDigestCacheMutex.RUnlock()
+	if ok {
+		return digests
+	}
+
+	digests = sliceDigests(body, config.sliceSize)
+	sliceDigestCacheMutex.Lock()
+	if len(sliceDigestCache) >= maxSliceDigestEntries {
+		sliceDigestCache = make(map[respCacheKey][]string)
+	}
+	sliceDigestCache[key] = digests
+	sliceDigestCacheMutex.Unlock()
+	return digests
+}
+
+// setSliceDigestHeader 源站在响应中带上完整对象的分片摘要或分片清单路径
+func setSliceDigestHeader(w http.ResponseWriter, r *http.Request, seed uint64, body []byte) {
+	digests := objectSliceDigests(seed, body)
+	prefix := strconv.Itoa(config.sliceSize) + ";"
+	if len(digests) <= config.sliceMD5Max {
+		w.Header().Set(sliceMD5HeaderName, prefix+strings.Join(digests, ","))
+	} else {
+		w.Header().Set(sliceManifestHeaderName, prefix+r.URL.Path+sliceManifestSuffix)
+	}
+}
+
+// serveSliceManifest 返回对象的分片清单：
+//
+//	slice-size <分片大小>
+//	object-size <对象大小>
+//	<分片0 md5>
+//	...
+func serveSliceManifest(w http.ResponseWriter, r *http.Request) {
+	objPath := strings.TrimSuffix(r.URL.Path, sliceManifestSuffix)
+	obj := parseObjectPath(objPath)
+	if obj.version == 0 {
+		if v, err := strconv.ParseInt(r.Header.Get("x-press-version"), 10, 64); err == nil {
+			obj.version = v
+		}
+	}
+	seed := contentSeed(contentHost(r.Host), objPath, obj.version)
+	body := getResponseBody(seed, serverGetRespSize(r, obj))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "slice-size %d\nobject-size %d\n", config.sliceSize, len(body))
+	for _, d := range objectSliceDigests(seed, body) {
+		buf.WriteString(d)
+		buf.WriteByte('\n')
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("X-Press-Version", strconv.FormatInt(obj.version, 10))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// parseSliceManifest 解析分片清单，返回分片大小和分片摘要
+func parseSliceManifest(r io.Reader) (int64, []string, error) {
+	var sliceSize int64
+	var digests []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "slice-size "):
+			n, err := strconv.ParseInt(strings.TrimPrefix(line, "slice-size "), 10, 64)
+			if err != nil {
+				return 0, nil, fmt.Errorf("无效的分片大小: %q", line)
+			}
+			sliceSize = n
+		case strings.HasPrefix(line, "object-size "):
+		default:
+			digests = append(digests, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+	if sliceSize <= 0 {
+		return 0, nil, fmt.Errorf("分片清单缺少 slice-size")
+	}
+	return sliceSize, digests, nil
+}
+
+// sliceVerifier 客户端按对象内的绝对偏移计算收到的完整分片的 MD5
+type sliceVerifier struct {
+	sliceSize    int64
+	objectSize   int64 // 对象大小，未知时为 0，此时最后一个分片不参与校验
+	offset       int64 // 下一个收到的字节在对象中的偏移
+	expected     []string
+	manifestPath string
+
+	hasher   hash.Hash
+	hashing  bool
+	computed map[int64]string // 分片序号 -> 收到内容的 md5
+
+	mismatch      bool
+	mismatchSlice int64
+	verified      int
+}
+
+// newSliceVerifier 根据响应创建分片校验器，源站未返回分片摘要或响应无法校验时返回 nil
+func newSliceVerifier(urlPath string, resp *http.Response) *sliceVerifier {
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return nil
+	}
+
+	v := &sliceVerifier{objectSize: int64(parseObjectPath(urlPath).size)}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if resp.ContentLength > 0 && v.objectSize == 0 {
+			v.objectSize = resp.ContentLength
+		}
+	case http.StatusPartialContent:
+		start, _, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok {
+			return nil
+		}
+		v.offset = start
+		if total >= 0 {
+			v.objectSize = total
+		}
+	default:
+		return nil
+	}
+
+	if s := resp.Header.Get(sliceMD5HeaderName); s != "" {
+		size, list, _ := strings.Cut(s, ";")
+		v.sliceSize, _ = strconv.ParseInt(size, 10, 64)
+		if list != "" {
+			v.expected = strings.Split(list, ",")
+		}
+	} else if s := resp.Header.Get(sliceManifestHeaderName); s != "" {
+		size, manifest, _ := strings.Cut(s, ";")
+		v.sliceSize, _ = strconv.ParseInt(size, 10, 64)
+		v.manifestPath = manifest
+	}
+	if v.sliceSize <= 0 {
+		return nil
+	}
+	v.hasher = md5.New()
+	v.computed = make(map[int64]string)
+	return v
+}
+
+// Write 按分片边界计算收到数据的 MD5，不从分片起点开始的部分跳过
+func (v *sliceVerifier) Write(p []byte) {
+	for len(p) > 0 {
+		idx := v.offset / v.sliceSize
+		sliceStart := idx * v.sliceSize
+		sliceEnd := sliceStart + v.sliceSize
+		if v.objectSize > 0 && sliceEnd > v.objectSize {
+			sliceEnd = v.objectSize
+		}
+		if v.offset == sliceStart {
+			v.hasher.Reset()
+			v.hashing = true
+		}
+		n := int64(len(p))
+		if rest := sliceEnd - v.offset; n > rest {
+			n = rest
+		}
+		if v.hashing {
+			v.hasher.Write(p[:n])
+			if v.offset+n == sliceEnd {
+				v.computed[idx] = hex.EncodeToString(v.hasher.Sum(nil))
+				v.hashing = false
+			}
+		}
+		v.offset += n
+		p = p[n:]
+	}
+}
+
+// needManifest 是否需要获取分片清单才能完成校验
+func (v *sliceVerifier) needManifest() bool {
+	return v.expected == nil && v.manifestPath != "" && len(v.computed) > 0
+}
+
+// finish 将收到的完整分片与源站摘要比较，记录序号最小的不一致分片
+func (v *sliceVerifier) finish() {
+	for idx, sum := range v.computed {
+		if idx >= int64(len(v.expected)) {
+			continue
+		}
+		v.verified++
+		if sum != v.expected[idx] && (!v.mismatch || idx < v.mismatchSlice) {
+			v.mismatch = true
+			v.mismatchSlice = idx
+		}
+	}
+}
+
+func (v *sliceVerifier) String() string {
+	start := v.mismatchSlice * v.sliceSize
+	end := start + v.sliceSize
+	if v.objectSize > 0 && end > v.objectSize {
+		end = v.objectSize
+	}
+	return fmt.Sprintf("分片大小: %d, 首个不一致分片: %d (偏移 %d-%d), 期望MD5: %s, 实际MD5: %s",
+		v.sliceSize, v.mismatchSlice, start, end-1,
+		v.expected[v.mismatchSlice], v.computed[v.mismatchSlice])
+}
+
+// sliceManifestCache 客户端缓存的分片清单，键为清单路径
+var (
+	sliceManifestCache      = make(map[string][]string)
+	sliceManifestCacheMutex sync.Mutex
+)
+
+// maxSliceManifestEntries 客户端分片清单缓存的数量上限，超过后清空
+const maxSliceManifestEntries = 10000
+
+// fetchSliceManifest 通过压测目标获取分片清单，结果按清单路径缓存
+func fetchSliceManifest(client *http.Client, manifestPath string) ([]string, error) {
+	sliceManifestCacheMutex.Lock()
+	digests, ok := sliceManifestCache[manifestPath]
+	sliceManifestCacheMutex.Unlock()
+	if ok {
+		return digests, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, getBaseURL()+manifestPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 与 doRequest 一致，URL 中没有大小时不发送 x-press-size，由源站决定
+	objPath := strings.TrimSuffix(manifestPath, sliceManifestSuffix)
+	if size := parseObjectPath(objPath).size; size > 0 {
+		req.Header.Set("x-press-size", strconv.Itoa(size))
+	}
+	req.Host = config.host
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取分片清单失败: %d", resp.StatusCode)
+	}
+	_, digests, err = parseSliceManifest(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	sliceManifestCacheMutex.Lock()
+	if len(sliceManifestCache) >= maxSliceManifestEntries {
+		sliceManifestCache = make(map[string][]string)
+	}
+	sliceManifestCache[manifestPath] = digests
+	sliceManifestCacheMutex.Unlock()
+	return digests, nil
+}
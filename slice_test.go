@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func sliceResponse(status int, contentRange string, sliceSize int, digests []string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}}
+	if contentRange != "" {
+		resp.Header.Set("Content-Range", contentRange)
+	}
+	resp.Header.Set(sliceMD5HeaderName, strconv.Itoa(sliceSize)+";"+strings.Join(digests, ","))
+	return resp
+}
+
+func TestSliceVerifier(t *testing.T) {
+	const urlPath = "/path1_s1000.js"
+	body := genContent(contentSeed("test.com", urlPath, 0), 1000)
+	digests := sliceDigests(body, 300)
+	if len(digests) != 4 {
+		t.Fatalf("expected 4 slices, got %d", len(digests))
+	}
+
+	// 完整响应，分多次写入，包括最后一个不满的分片
+	v := newSliceVerifier(urlPath, sliceResponse(http.StatusOK, "", 300, digests))
+	v.Write(body[:250])
+	v.Write(body[250:700])
+	v.Write(body[700:])
+	v.finish()
+	if v.mismatch || v.verified != 4 {
+		t.Fatalf("full response: mismatch=%v verified=%d", v.mismatch, v.verified)
+	}
+
+	// 跨分片边界的 Range 响应只校验完整覆盖的分片 1、2
+	v = newSliceVerifier(urlPath, sliceResponse(http.StatusPartialContent, "bytes 250-949/1000", 300, digests))
+	v.Write(body[250:950])
+	v.finish()
+	if v.mismatch || v.verified != 2 {
+		t.Fatalf("range response: mismatch=%v verified=%d", v.mismatch, v.verified)
+	}
+
+	// 篡改分片 2 中的一个字节
+	part := append([]byte(nil), body[250:950]...)
+	part[700-250] ^= 0xff
+	v = newSliceVerifier(urlPath, sliceResponse(http.StatusPartialContent, "bytes 250-949/1000", 300, digests))
+	v.Write(part)
+	v.finish()
+	if !v.mismatch || v.mismatchSlice != 2 {
+		t.Fatalf("expected mismatch in slice 2, got %v slice %d", v.mismatch, v.mismatchSlice)
+	}
+
+	// 首尾不完整分片中的错误不会被误报
+	part = append([]byte(nil), body[250:950]...)
+	part[0] ^= 0xff
+	part[len(part)-1] ^= 0xff
+	v = newSliceVerifier(urlPath, sliceResponse(http.StatusPartialContent, "bytes 250-949/1000", 300, digests))
+	v.Write(part)
+	v.finish()
+	if v.mismatch {
+		t.Fatalf("partial edge slices should not be verified: %s", v)
+	}
+}
+
+func TestParseSliceManifest(t *testing.T) {
+	size, digests, err := parseSliceManifest(strings.NewReader("slice-size 300\nobject-size 1000\naa\nbb\n"))
+	if err != nil || size != 300 || len(digests) != 2 || digests[1] != "bb" {
+		t.Fatalf("got %d %v %v", size, digests, err)
+	}
+	if _, _, err := parseSliceManifest(strings.NewReader("aa\n")); err == nil {
+		t.Fatalf("manifest without slice-size should fail")
+	}
+}
+
+func TestFetchSliceManifestWithoutSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(serveSliceManifest))
+	defer srv.Close()
+	oldAddr, oldHost, oldSliceSize := config.addr, config.host, config.sliceSize
+	t.Cleanup(func() {
+		config.addr, config.host, config.sliceSize = oldAddr, oldHost, oldSliceSize
+		sliceManifestCache = make(map[string][]string)
+	})
+	config.addr, config.host, config.sliceSize = srv.URL, "test.com", 300
+
+	// URL 中没有大小时使用源站默认大小，而不是空清单
+	seed := contentSeed("test.com", "/path1.js", 0)
+	want := objectSliceDigests(seed, genContent(seed, 1024))
+	digests, err := fetchSliceManifest(srv.Client(), "/path1.js"+sliceManifestSuffix)
+	if err != nil || len(digests) == 0 || strings.Join(digests, ",") != strings.Join(want, ",") {
+		t.Fatalf("no size: %v %v, want %v", digests, err, want)
+	}
+
+	seed = contentSeed("test.com", "/path2_s600.js", 0)
+	want = objectSliceDigests(seed, genContent(seed, 600))
+	digests, err = fetchSliceManifest(srv.Client(), "/path2_s600.js"+sliceManifestSuffix)
+	if err != nil || strings.Join(digests, ",") != strings.Join(want, ",") {
+		t.Fatalf("size in URL: %v %v, want %v", digests, err, want)
+	}
+}
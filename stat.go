@@ -2,68 +2,104 @@ package main
 
 import (
 	"fmt"
-	"math"
+	"log"
+	"math/bits"
 	"sync/atomic"
 	"time"
 )
 
-func clientStat() {
+// sizeHistogram 按 2 的幂分桶统计观测到的响应体大小，桶 i 包含 [2^(i-1), 2^i) 字节
+type sizeHistogram struct {
+	buckets [65]int64
+}
+
+var observedSizes sizeHistogram
+
+func (h *sizeHistogram) record(size int64) {
+	atomic.AddInt64(&h.buckets[bits.Len64(uint64(size))], 1)
+}
 
-	var cacheHitRatio float64
+func (h *sizeHistogram) print() {
+	var total int64
+	for i := range h.buckets {
+		total += atomic.LoadInt64(&h.buckets[i])
+	}
+	if total == 0 {
+		return
+	}
+	for i := range h.buckets {
+		n := atomic.LoadInt64(&h.buckets[i])
+		if n == 0 {
+			continue
+		}
+		lo, hi := int64(0), int64(0)
+		if i > 0 {
+			lo, hi = int64(1)<<(i-1), int64(1)<<i-1
+		}
+		fmt.Printf("  [%d, %d]: %d (%.2f%%)\n", lo, hi, n, float64(n)/float64(total)*100)
+	}
+}
+
+// runLatency 整个压测期间的延迟统计，监控协程退出（statDone 关闭）后才可读取
+var runLatency latencyStats
+
+// statDone 监控协程处理完剩余统计数据后关闭
+var statDone chan struct{}
+
+func clientStat() {
 	var startTime time.Time
-	var totalResponseTimeStat time.Duration
-	var totalFirstByteTimeStat time.Duration
-	var avgFirstByteTimeStat, avgResponseTimeStat time.Duration
-	var minFirstByteTimeStat, maxFirstByteTimeStat time.Duration
-	var minResponseTimeStat, maxResponseTimeStat time.Duration
 	var round int64
 
+	// 当前统计周期的延迟直方图，每个周期结束时合并到 runLatency
+	var interval latencyStats
+
 	startTime = time.Now()
+	statDone = make(chan struct{})
+
+	var series *timeSeriesWriter
+	if config.timeSeriesCSV != "" {
+		var err error
+		series, err = newTimeSeriesWriter(config.timeSeriesCSV, startTime)
+		if err != nil {
+			log.Fatal("创建时间序列文件失败: ", err)
+		}
+	}
 
 	// 监控协程
 	go func() {
+		defer close(statDone)
 		ticker := time.NewTicker(config.tickerDump)
 		defer ticker.Stop()
-		var cnt, cacheHits int64
 
 		for {
 			select {
 			case <-done:
-				return
-			case reqStat := <-reqStatCh:
-				// 处理请求统计信息（如果需要）
-				cnt++
-				totalFirstByteTimeStat += reqStat.firstByteTime
-				totalResponseTimeStat += reqStat.respTime
-				avgFirstByteTimeStat = time.Duration(int64(totalFirstByteTimeStat) / cnt)
-				avgResponseTimeStat = time.Duration(int64(totalResponseTimeStat) / cnt)
-				if minFirstByteTimeStat == 0 {
-					minFirstByteTimeStat = time.Duration(reqStat.firstByteTime)
-				} else {
-					minFirstByteTimeStat = time.Duration(math.Min(float64(minFirstByteTimeStat), float64(reqStat.firstByteTime)))
-				}
-
-				if minResponseTimeStat == 0 {
-					minResponseTimeStat = time.Duration(reqStat.respTime)
-				} else {
-					minResponseTimeStat = time.Duration(math.Min(float64(minResponseTimeStat), float64(reqStat.respTime)))
+				// 处理通道中剩余的统计数据
+				for {
+					select {
+					case reqStat := <-reqStatCh:
+						interval.record(reqStat)
+						if stageStats != nil {
+							stageStats.record(reqStat)
+						}
+						continue
+					default:
+					}
+					break
 				}
-
-				if maxFirstByteTimeStat == 0 {
-					maxFirstByteTimeStat = time.Duration(reqStat.firstByteTime)
-				} else {
-					maxFirstByteTimeStat = time.Duration(math.Max(float64(maxFirstByteTimeStat), float64(reqStat.firstByteTime)))
-				}
-
-				if maxResponseTimeStat == 0 {
-					maxResponseTimeStat = time.Duration(reqStat.respTime)
-				} else {
-					maxResponseTimeStat = time.Duration(math.Max(float64(maxResponseTimeStat), float64(reqStat.respTime)))
+				if series != nil {
+					// 最后一个不完整的周期
+					round++
+					series.write(round, &interval)
+					series.close()
 				}
-
-				if reqStat.cacheHit {
-					cacheHits++
-					cacheHitRatio = float64(cacheHits) / float64(cnt) * 100
+				runLatency.merge(&interval)
+				return
+			case reqStat := <-reqStatCh:
+				// 处理请求统计信息
+				interval.record(reqStat)
+				if stageStats != nil {
+					stageStats.record(reqStat)
 				}
 
 			case <-ticker.C:
@@ -71,22 +107,27 @@ func clientStat() {
 				elapsed := time.Since(startTime).Seconds()
 				currentTotal := atomic.LoadInt64(&totalRequests)
 
-				// 计算平均时间指标
-				fmt.Printf("统计次%d: 总请求数=%d, 成功=%d, 失败=%d, 总字节数=%d, QPS=%.2f, 已用时=%.2fs, 缓存命中率=%.2f%%\n\n",
-					round, currentTotal, successRequests, failedRequests, totalBytes,
+				cacheHitRatio := 0.0
+				if cnt := interval.count(); cnt > 0 {
+					cacheHitRatio = float64(interval.hits()) / float64(cnt) * 100
+				}
+
+				fmt.Printf("统计次%d: 总请求数=%d, 成功=%d, 失败=%d, HTTP错误=%d, 内容损坏=%d, 总字节数=%d, QPS=%.2f, 已用时=%.2fs, 缓存命中率=%.2f%%\n\n",
+					round, currentTotal, successRequests, failedRequests, atomic.LoadInt64(&httpErrorRequests),
+					atomic.LoadInt64(&corruptRequests), totalBytes,
 					float64(currentTotal)/elapsed, elapsed, cacheHitRatio)
-				fmt.Printf("      》》》平均首包时间=%v, 平均响应时间=%v, 最大首包时间=%v, 最大响应时间=%v 最小首包时间=%v, 最小响应时间=%v\n\n\n\n",
-					avgFirstByteTimeStat, avgResponseTimeStat, maxFirstByteTimeStat, maxResponseTimeStat, minFirstByteTimeStat, minResponseTimeStat)
-
-				totalFirstByteTimeStat = 0
-				totalResponseTimeStat = 0
-				cnt = 0
-				minFirstByteTimeStat = 0
-				maxFirstByteTimeStat = 0
-				minResponseTimeStat = 0
-				maxResponseTimeStat = 0
-				cacheHits = 0
-				cacheHitRatio = 0
+				if runProfile != nil {
+					t := time.Since(startTime)
+					fmt.Printf("      负载曲线: 阶段 %d/%d, 目标QPS=%.1f\n", runProfile.stageAt(t)+1, len(runProfile.stages), runProfile.qpsAt(t))
+				}
+				interval.print("      》》》")
+				fmt.Printf("\n\n\n")
+				if series != nil {
+					series.write(round, &interval)
+				}
+
+				runLatency.merge(&interval)
+				interval.reset()
 			}
 		}
 
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// URL 状态文件 (-url-state)：记录客户端已经发出过的 URL（id、对象大小、版本号、最后访问时间）和 id 计数器，
+// 退出时保存，下次启动时加载。从状态文件加载的对象沿用文件中的大小和版本号（即使 -size-dist 已改变），
+// 多天的长稳测试或多台机器加载同一个文件时看到的是同一批已缓存的对象。
+//
+// 文件为文本格式，第一行为计数器，之后每行一个对象:
+//
+//	# cache_press url-state id=12345 not_hit_id=678
+//	id,size,version,last_access_unix_ms
+
+const urlStateMagic = "# cache_press url-state"
+
+// maxURLStateID 状态文件中超出 -url-count 的 id 上限，避免损坏的文件导致无限制分配内存
+const maxURLStateID = 1 << 24
+
+// urlEntry 一个 URL 的状态
+type urlEntry struct {
+	lastAccess int64 // Unix 毫秒，0 表示从未访问，运行中原子更新
+	size       int
+	version    int64
+	loaded     bool // 大小和版本号来自状态文件
+}
+
+// urlStateSet 按 id 索引的 URL 状态，运行中只原子更新 lastAccess
+type urlStateSet struct {
+	entries []urlEntry
+}
+
+var urlState *urlStateSet
+
+func newURLStateSet(urlCount int) *urlStateSet {
+	return &urlStateSet{entries: make([]urlEntry, urlCount+1)}
+}
+
+// apply 记录一次访问，已加载的对象使用状态文件中的大小和版本号
+func (s *urlStateSet) apply(id int64, obj pressObject) pressObject {
+	if s == nil || id < 0 || id >= int64(len(s.entries)) {
+		return obj
+	}
+	e := &s.entries[id]
+	atomic.StoreInt64(&e.lastAccess, time.Now().UnixMilli())
+	if e.loaded {
+		obj.size = e.size
+		obj.version = e.version
+	}
+	return obj
+}
+
+// load 读取状态文件，文件不存在时返回 false
+func (s *urlStateSet) load(file string) (bool, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), urlStateMagic) {
+		return false, fmt.Errorf("%s 不是 URL 状态文件", file)
+	}
+	var issued, notHit int64
+	for _, kv := range strings.Fields(strings.TrimPrefix(scanner.Text(), urlStateMagic)) {
+		k, v, _ := strings.Cut(kv, "=")
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("无效的计数器 %q", kv)
+		}
+		switch k {
+		case "id":
+			issued = n
+		case "not_hit_id":
+			notHit = n
+		}
+	}
+
+	line := 1
+	for scanner.Scan() {
+		line++
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) != 4 {
+			return false, fmt.Errorf("%s:%d: 应为 id,size,version,last_access", file, line)
+		}
+		var v [4]int64
+		for i, f := range fields {
+			if v[i], err = strconv.ParseInt(f, 10, 64); err != nil || v[i] < 0 {
+				return false, fmt.Errorf("%s:%d: 无效的数值 %q", file, line, f)
+			}
+		}
+		if v[0] >= int64(len(s.entries)) {
+			if v[0] > maxURLStateID {
+				return false, fmt.Errorf("%s:%d: id %d 超过上限 %d", file, line, v[0], maxURLStateID)
+			}
+			n := min(max(2*int64(len(s.entries)), v[0]+1), maxURLStateID+1)
+			s.entries = append(s.entries, make([]urlEntry, n-int64(len(s.entries)))...)
+		}
+		s.entries[v[0]] = urlEntry{size: int(v[1]), version: v[2], lastAccess: v[3], loaded: true}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	atomic.StoreInt64(&id, issued)
+	atomic.StoreInt64(&notHitID, notHit)
+	return true, nil
+}
+
+// save 写入状态文件：加载的对象和本次运行访问过的对象，先写临时文件再改名
+func (s *urlStateSet) save(file string) (int, error) {
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "%s id=%d not_hit_id=%d\n", urlStateMagic, getID(), atomic.LoadInt64(&notHitID))
+	n := 0
+	for i := range s.entries {
+		e := &s.entries[i]
+		last := atomic.LoadInt64(&e.lastAccess)
+		if !e.loaded && last == 0 {
+			continue
+		}
+		size, version := e.size, e.version
+		if !e.loaded {
+			size = objectSizeFor(int64(i))
+		}
+		fmt.Fprintf(w, "%d,%d,%d,%d\n", i, size, version, last)
+		n++
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp, file)
+}
+
+// loadURLState 启用 -url-state 时创建 URL 状态并加载已有的状态文件
+func loadURLState() error {
+	if config.urlState == "" {
+		return nil
+	}
+	s := newURLStateSet(config.urlCount)
+	ok, err := s.load(config.urlState)
+	if err != nil {
+		return err
+	}
+	urlState = s
+	if ok {
+		loaded := 0
+		for i := range s.entries {
+			if s.entries[i].loaded {
+				loaded++
+			}
+		}
+		fmt.Printf("已加载 URL 状态 %s: %d 个对象, id 计数器=%d\n", config.urlState, loaded, getID())
+	}
+	return nil
+}
+
+// saveURLState 在压测结束时保存 URL 状态
+func saveURLState() {
+	if urlState == nil || !config.urlStateSave {
+		return
+	}
+	n, err := urlState.save(config.urlState)
+	if err != nil {
+		fmt.Println("保存 URL 状态失败:", err)
+		return
+	}
+	fmt.Printf("已保存 URL 状态 %s: %d 个对象, id 计数器=%d\n", config.urlState, n, getID())
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestURLStateSaveLoad(t *testing.T) {
+	oldDist, oldID, oldNotHit := respSizeDist, getID(), atomic.LoadInt64(&notHitID)
+	defer func() {
+		respSizeDist = oldDist
+		atomic.StoreInt64(&id, oldID)
+		atomic.StoreInt64(&notHitID, oldNotHit)
+	}()
+	respSizeDist = minMaxSize{1024, 1 << 20, 0.7}
+	file := filepath.Join(t.TempDir(), "urls.state")
+
+	s := newURLStateSet(10)
+	s.apply(3, pressObject{size: objectSizeFor(3)})
+	s.apply(7, pressObject{size: objectSizeFor(7)})
+	atomic.StoreInt64(&id, 8)
+	atomic.StoreInt64(&notHitID, 2)
+	if n, err := s.save(file); err != nil || n != 2 {
+		t.Fatalf("save: %d %v", n, err)
+	}
+
+	// 下一次运行使用不同的大小分布和计数器，加载后应沿用文件中的大小
+	respSizeDist = minMaxSize{10, 20, 0}
+	atomic.StoreInt64(&id, 0)
+	atomic.StoreInt64(&notHitID, 0)
+	loaded := newURLStateSet(5)
+	ok, err := loaded.load(file)
+	if err != nil || !ok {
+		t.Fatalf("load: %v %v", ok, err)
+	}
+	if getID() != 8 || atomic.LoadInt64(&notHitID) != 2 {
+		t.Fatalf("counters: id=%d not_hit_id=%d", getID(), notHitID)
+	}
+	want := minMaxSize{1024, 1 << 20, 0.7}.size(idUniform(7, 0))
+	if obj := loaded.apply(7, pressObject{size: objectSizeFor(7)}); obj.size != want {
+		t.Fatalf("id 7 size = %d, want %d", obj.size, want)
+	}
+	// 未加载的 id 仍按当前分布
+	if obj := loaded.apply(4, pressObject{size: 15}); obj.size != 15 {
+		t.Fatalf("id 4 size = %d", obj.size)
+	}
+
+	// 再次保存：原有的两个对象加上本次访问的 id 4
+	if n, err := loaded.save(file); err != nil || n != 3 {
+		t.Fatalf("resave: %d %v", n, err)
+	}
+	data, _ := os.ReadFile(file)
+	if !strings.HasPrefix(string(data), urlStateMagic+" id=8 not_hit_id=2\n") {
+		t.Fatalf("header: %q", data)
+	}
+}
+
+func TestURLStateLoadMissingAndInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if ok, err := newURLStateSet(1).load(filepath.Join(dir, "none")); ok || err != nil {
+		t.Fatalf("missing file: %v %v", ok, err)
+	}
+	bad := filepath.Join(dir, "bad")
+	os.WriteFile(bad, []byte("hello\n"), 0644)
+	if _, err := newURLStateSet(1).load(bad); err == nil {
+		t.Fatal("expected error for non state file")
+	}
+	os.WriteFile(bad, []byte(urlStateMagic+" id=1\n1,2,x,4\n"), 0644)
+	if _, err := newURLStateSet(1).load(bad); err == nil {
+		t.Fatal("expected error for invalid line")
+	}
+	os.WriteFile(bad, []byte(urlStateMagic+" id=1\n1000000000000000,2,3,4\n"), 0644)
+	if _, err := newURLStateSet(1).load(bad); err == nil || !strings.Contains(err.Error(), "上限") {
+		t.Fatalf("expected error for huge id: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cache_press/pkg/buffer"
+)
+
+// verifyWindow 内容校验失败时输出的期望/实际字节窗口大小
+const verifyWindow = 16
+
+// bodyVerifier 将收到的响应体与源站确定性生成的内容逐字节比对
+type bodyVerifier struct {
+	seed     uint64
+	offset   int64 // 下一个收到的字节在对象中的偏移
+	expected *[]byte
+
+	mismatch       bool
+	mismatchOffset int64
+	expectedWindow []byte
+	actualWindow   []byte
+}
+
+// parseContentRange 解析单区间 Content-Range 头 "bytes a-b/N"，返回区间起止偏移和对象大小，
+// 对象大小未知 ("bytes a-b/*") 时 total 为 -1
+func parseContentRange(s string) (start, end, total int64, ok bool) {
+	const b = "bytes "
+	if !strings.HasPrefix(s, b) {
+		return 0, 0, 0, false
+	}
+	spec, totalStr, found := strings.Cut(s[len(b):], "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	var err1, err2 error
+	start, err1 = strconv.ParseInt(startStr, 10, 64)
+	end, err2 = strconv.ParseInt(endStr, 10, 64)
+	if err1 != nil || err2 != nil || start > end {
+		return 0, 0, 0, false
+	}
+	total = -1
+	if totalStr != "*" {
+		if total, err1 = strconv.ParseInt(totalStr, 10, 64); err1 != nil {
+			return 0, 0, 0, false
+		}
+	}
+	return start, end, total, true
+}
+
+// expectedBodyLength 返回响应体应有的长度：完整响应为 URL 中编码的对象大小，
+// 单区间响应为 Content-Range 的区间长度，其他情况（压缩、多区间）以 Content-Length 为准。
+// 无法确定时返回 false
+func expectedBodyLength(urlPath string, resp *http.Response) (int64, bool) {
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return resp.ContentLength, resp.ContentLength >= 0
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if obj := parseObjectPath(urlPath); obj.sized {
+			return int64(obj.size), true
+		}
+	case http.StatusPartialContent:
+		if start, end, _, ok := parseContentRange(resp.Header.Get("Content-Range")); ok {
+			return end - start + 1, true
+		}
+	default:
+		return 0, false
+	}
+	return resp.ContentLength, resp.ContentLength >= 0
+}
+
+// newBodyVerifier 根据响应创建校验器，无法校验的响应（压缩、多区间等）返回 nil
+func newBodyVerifier(urlPath string, resp *http.Response) *bodyVerifier {
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return nil
+	}
+
+	var offset int64
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPartialContent:
+		start, _, _, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok {
+			// multipart/byteranges 暂不校验
+			return nil
+		}
+		offset = start
+	default:
+		return nil
+	}
+
+	// 优先使用源站返回的版本号，缓存的响应头与响应体应属于同一版本
+	version := parseObjectPath(urlPath).version
+	if v, err := strconv.ParseInt(resp.Header.Get("X-Press-Version"), 10, 64); err == nil {
+		version = v
+	}
+
+	return &bodyVerifier{
+		seed:     clientContentSeed(urlPath, version),
+		offset:   offset,
+		expected: buffer.GetBytes(35840),
+	}
+}
+
+// Write 校验下一段收到的数据，只记录第一个不一致的位置
+func (v *bodyVerifier) Write(p []byte) {
+	for len(p) > 0 && !v.mismatch {
+		exp := *v.expected
+		n := len(p)
+		if n > cap(exp) {
+			n = cap(exp)
+		}
+		exp = exp[:n]
+		fillContent(v.seed, v.offset, exp)
+		for i := 0; i < n; i++ {
+			if exp[i] == p[i] {
+				continue
+			}
+			v.mismatch = true
+			v.mismatchOffset = v.offset + int64(i)
+			v.expectedWindow = make([]byte, verifyWindow)
+			fillContent(v.seed, v.mismatchOffset, v.expectedWindow)
+			end := i + verifyWindow
+			if end > len(p) {
+				end = len(p)
+			}
+			v.actualWindow = append([]byte(nil), p[i:end]...)
+			break
+		}
+		v.offset += int64(n)
+		p = p[n:]
+	}
+}
+
+// release 归还校验缓冲区
+func (v *bodyVerifier) release() {
+	buffer.PutBytes(v.expected)
+	v.expected = nil
+}
+
+func (v *bodyVerifier) String() string {
+	return fmt.Sprintf("首个不一致偏移: %d, 期望: %q, 实际: %q",
+		v.mismatchOffset, v.expectedWindow, v.actualWindow)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBodyVerifier(t *testing.T) {
+	config.host = "test.com"
+	const urlPath = "/path1.js"
+	body := genContent(clientContentSeed(urlPath, 0), 1000)
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	v := newBodyVerifier(urlPath, resp)
+	v.Write(body[:300])
+	v.Write(body[300:])
+	v.release()
+	if v.mismatch {
+		t.Fatalf("unexpected mismatch: %s", v)
+	}
+
+	// 从 Range 起始偏移开始校验，并定位到第一个被篡改的字节
+	resp = &http.Response{StatusCode: http.StatusPartialContent, Header: http.Header{}}
+	resp.Header.Set("Content-Range", "bytes 500-999/1000")
+	part := append([]byte(nil), body[500:]...)
+	part[123] ^= 0xff
+	v = newBodyVerifier(urlPath, resp)
+	v.Write(part)
+	v.release()
+	if !v.mismatch || v.mismatchOffset != 623 {
+		t.Fatalf("expected mismatch at 623, got %v at %d", v.mismatch, v.mismatchOffset)
+	}
+
+	// 版本号不一致时内容不同
+	resp = &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	resp.Header.Set("X-Press-Version", "3")
+	v = newBodyVerifier(urlPath, resp)
+	v.Write(body)
+	v.release()
+	if !v.mismatch {
+		t.Fatal("expected mismatch for a different version")
+	}
+
+	resp.Header.Set("Content-Encoding", "gzip")
+	if newBodyVerifier(urlPath, resp) != nil {
+		t.Fatal("encoded responses should not be verified")
+	}
+}
+
+func TestExpectedBodyLength(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, ContentLength: 500}
+	if n, ok := expectedBodyLength("/path1_s1000.js", resp); !ok || n != 1000 {
+		t.Errorf("full response: got %d %v, want 1000", n, ok)
+	}
+	if n, ok := expectedBodyLength("/path1.js", resp); !ok || n != 500 {
+		t.Errorf("no size in URL: got %d %v, want Content-Length 500", n, ok)
+	}
+
+	resp = &http.Response{StatusCode: http.StatusPartialContent, Header: http.Header{}, ContentLength: -1}
+	resp.Header.Set("Content-Range", "bytes 100-199/1000")
+	if n, ok := expectedBodyLength("/path1_s1000.js", resp); !ok || n != 100 {
+		t.Errorf("range response: got %d %v, want 100", n, ok)
+	}
+
+	resp = &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, ContentLength: -1}
+	resp.Header.Set("Content-Encoding", "gzip")
+	if _, ok := expectedBodyLength("/path1_s1000.js", resp); ok {
+		t.Errorf("encoded response without Content-Length should be unknown")
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	start, end, total, ok := parseContentRange("bytes 10-19/100")
+	if !ok || start != 10 || end != 19 || total != 100 {
+		t.Errorf("got %d-%d/%d %v", start, end, total, ok)
+	}
+	if _, _, total, ok = parseContentRange("bytes 0-9/*"); !ok || total != -1 {
+		t.Errorf("unknown total: got %d %v", total, ok)
+	}
+	for _, s := range []string{"", "bytes */100", "bytes 9-0/100", "items 0-9/100"} {
+		if _, _, _, ok := parseContentRange(s); ok {
+			t.Errorf("%q should be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/ratelimit"
+)
+
+// 正式压测之前的两个可选阶段，统计结束后清零，不计入最终统计，也不记录 /metrics 指标：
+//   - 预填充 (-prefill): 按 -prefill-qps 依次请求 id 为 1..N 的 URL（或热度模型的整个热点集合），
+//     让 CDN 缓存进入稳定状态；之后 ratio 模型的 id 计数器从 N 开始，预填充的 URL 视为已访问过
+//   - 预热 (-warmup): 按正式压测的方式以 -qps 压测指定时长
+//
+// 每个阶段的请求数、命中率等单独输出，并记录在 JSON 报告的 phases 中
+
+// phaseReport 预填充或预热阶段的统计
+type phaseReport struct {
+	Name          string                    `json:"name"`
+	StartTime     time.Time                 `json:"start_time"`
+	EndTime       time.Time                 `json:"end_time"`
+	DurationSec   float64                   `json:"duration_sec"`
+	Requests      int64                     `json:"requests"`
+	Success       int64                     `json:"success"`
+	Failed        int64                     `json:"failed"`
+	HTTPErrors    int64                     `json:"http_errors"`
+	Bytes         int64                     `json:"bytes"`
+	CacheHitRatio float64                   `json:"cache_hit_ratio"`
+	Latency       map[string]latencySummary `json:"latency"`
+}
+
+// warmupPhases 已完成的预填充和预热阶段
+var warmupPhases []phaseReport
+
+// hotSetSizer 有热点集合的热度模型，热点集合为 id 从 1 开始的前 hotSetSize 个 URL
+type hotSetSizer interface {
+	hotSetSize() int64
+}
+
+func (p *hotColdPicker) hotSetSize() int64 {
+	return p.hotN
+}
+
+// prefillCount 解析 -prefill：URL 数量或 hot（热度模型的热点集合），0 表示不预填充
+func prefillCount(spec string, picker urlPicker, urlCount int) (int64, error) {
+	if spec == "" {
+		return 0, nil
+	}
+	if spec == "hot" {
+		h, ok := picker.(hotSetSizer)
+		if !ok {
+			return 0, fmt.Errorf("热度模型 %s 没有热点集合，-prefill 应指定 URL 数量", picker)
+		}
+		return h.hotSetSize(), nil
+	}
+	n, err := strconv.ParseInt(spec, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("无效的 -prefill %q，应为 URL 数量或 hot", spec)
+	}
+	return min(n, int64(urlCount)), nil
+}
+
+// collectPhase 在监控协程启动之前消费统计通道，返回的函数停止收集并返回该阶段的延迟统计
+func collectPhase() func() *latencyStats {
+	stats := &latencyStats{}
+	stop := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case r := <-reqStatCh:
+				stats.record(r)
+			case <-stop:
+				for {
+					select {
+					case r := <-reqStatCh:
+						stats.record(r)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return func() *latencyStats {
+		close(stop)
+		<-finished
+		return stats
+	}
+}
+
+// runPhase 执行一个阶段，结束后输出并记录该阶段的统计，然后清零全局统计。
+// 阶段执行期间暂停记录 /metrics 的请求指标
+func runPhase(name string, run func()) {
+	collect := collectPhase()
+	clientMetrics.pause(true)
+	start := time.Now()
+	run()
+	end := time.Now()
+	clientMetrics.pause(false)
+	latency := collect()
+
+	c := snapshotCounters()
+	p := phaseReport{
+		Name:          name,
+		StartTime:     start,
+		EndTime:       end,
+		DurationSec:   end.Sub(start).Seconds(),
+		Requests:      c.total,
+		Success:       c.success,
+		Failed:        c.failed,
+		HTTPErrors:    c.httpErrors,
+		Bytes:         c.bytes,
+		CacheHitRatio: ratio(latency.hits(), latency.count()),
+		Latency:       latency.summarize(),
+	}
+	warmupPhases = append(warmupPhases, p)
+	printPhase(p)
+	resetClientStats()
+}
+
+func printPhase(p phaseReport) {
+	fmt.Printf("%s阶段完成: %s - %s, 用时 %.2fs, 请求数=%d, 成功=%d, 失败=%d, HTTP错误=%d, 缓存命中率=%.2f%% (不计入最终统计)\n",
+		p.Name, p.StartTime.Format("15:04:05.000"), p.EndTime.Format("15:04:05.000"), p.DurationSec,
+		p.Requests, p.Success, p.Failed, p.HTTPErrors, p.CacheHitRatio*100)
+}
+
+// resetClientStats 清零客户端统计，调用时不能有在途请求
+func resetClientStats() {
+	for _, p := range []*int64{&totalRequests, &successRequests, &failedRequests, &totalBytes, &corruptRequests,
+		&hdrMismatchRequests, &shortBodyRequests, &longBodyRequests, &sliceMismatchRequests, &stalledRequests,
+		&httpErrorRequests, &lateRequests} {
+		atomic.StoreInt64(p, 0)
+	}
+	for i := range statusCounts {
+		atomic.StoreInt64(&statusCounts[i], 0)
+	}
+	observedSizes = sizeHistogram{}
+	sendLag.reset()
+	if headerChecker != nil {
+		headerChecker.mu.Lock()
+		headerChecker.counts = make(map[string]int64)
+		headerChecker.mu.Unlock()
+	}
+}
+
+// runPrefill 以 -prefill-qps 依次请求 id 为 1..n 的 URL
+func runPrefill(baseURL string, n int64) {
+	limiter := ratelimit.New(config.prefillQPS)
+	var next int64
+	var wg sync.WaitGroup
+	for i := 0; i < config.conns; i++ {
+		wg.Add(1)
+		go func(connID int) {
+			defer wg.Done()
+			client := &http.Client{
+				Timeout:   30 * time.Second,
+				Transport: transport,
+			}
+			for {
+				urlID := atomic.AddInt64(&next, 1)
+				if urlID > n {
+					return
+				}
+				limiter.Take()
+				doRequest(client, connID, http.MethodGet, genURL(baseURL, urlID), time.Time{})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// ratio 模型此后把预填充的 URL 当作已访问过的 URL
+	if getID() < n {
+		atomic.StoreInt64(&id, n)
+	}
+}
+
+// runWarmup 在正式压测之前执行预填充和预热阶段
+func runWarmup(baseURL string) {
+	n, err := prefillCount(config.prefill, urlPopularity, config.urlCount)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if n > 0 {
+		fmt.Printf("预填充: 请求 id 1-%d 的 URL, QPS=%d\n", n, config.prefillQPS)
+		runPhase("预填充", func() { runPrefill(baseURL, n) })
+	}
+	if config.warmup > 0 {
+		fmt.Printf("预热: QPS=%d, 持续 %v\n", config.qps, config.warmup)
+		runPhase("预热", func() {
+			start := time.Now()
+			if config.loadModel == loadModelOpen {
+				runOpenLoop(baseURL, start, config.warmup, func(time.Time) float64 { return float64(config.qps) })
+			} else {
+				runClosedLoop(baseURL, start, config.warmup, ratelimit.New(config.qps))
+			}
+		})
+	}
+	if len(warmupPhases) > 0 {
+		fmt.Printf("=== 正式压测开始: %s ===\n", time.Now().Format("15:04:05.000"))
+	}
+}
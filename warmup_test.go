@@ -0,0 +1,40 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestPrefillCount(t *testing.T) {
+	hot, err := parsePopularity("hotcold:0.1,0.9", 1000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := prefillCount("hot", hot, 1000); err != nil || n != 100 {
+		t.Fatalf("hot set: %d %v", n, err)
+	}
+	ratio, _ := parsePopularity("ratio", 1000, 0.5)
+	if _, err := prefillCount("hot", ratio, 1000); err == nil {
+		t.Fatal("ratio model has no hot set")
+	}
+	if n, err := prefillCount("5000", ratio, 1000); err != nil || n != 1000 {
+		t.Fatalf("capped at url count: %d %v", n, err)
+	}
+	if n, err := prefillCount("", ratio, 1000); err != nil || n != 0 {
+		t.Fatalf("empty: %d %v", n, err)
+	}
+	if _, err := prefillCount("abc", ratio, 1000); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestResetClientStats(t *testing.T) {
+	atomic.AddInt64(&totalRequests, 3)
+	atomic.AddInt64(&httpErrorRequests, 1)
+	recordStatus(503)
+	observedSizes.record(1024)
+	resetClientStats()
+	if totalRequests != 0 || httpErrorRequests != 0 || len(statusBreakdown()) != 0 || observedSizes.report() != nil {
+		t.Fatal("stats not reset")
+	}
+}